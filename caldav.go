@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+/*
+ * memo
+ *  an event is a resource "<collection>/garoon-<id>[-<candidate>].ics" holding a VCALENDAR.
+ *  extended properties are kept as X-GRN2GCAL-PRIVATE:key=value.
+ *  calendar.Event.Id is the href of a resource, and Etag its ETag.
+ */
+
+// caldavTarget ...
+// a CalDAV calendar collection
+type caldavTarget struct {
+	Client   *http.Client
+	URL      *url.URL
+	Username string
+	Password string
+}
+
+// newCalDAVTarget ...
+// the URL is validated by ValidateConfig
+func newCalDAVTarget(config *CalDAVConfig) *caldavTarget {
+	collection, _ := url.Parse(config.URL)
+	if !strings.HasSuffix(collection.Path, "/") {
+		collection.Path += "/"
+	}
+
+	return &caldavTarget{
+		Client:   &http.Client{Timeout: 60 * time.Second},
+		URL:      collection,
+		Username: config.Username,
+		Password: config.Password,
+	}
+}
+
+// REPORT calendar-query
+
+type caldavCalendarQuery struct {
+	XMLName xml.Name `xml:"C:calendar-query"`
+	XmlnsD  string   `xml:"xmlns:D,attr"`
+	XmlnsC  string   `xml:"xmlns:C,attr"`
+	Prop    struct {
+		ETag         struct{} `xml:"D:getetag"`
+		CalendarData struct{} `xml:"C:calendar-data"`
+	} `xml:"D:prop"`
+	Filter caldavCompFilter `xml:"C:filter>C:comp-filter"`
+}
+
+type caldavCompFilter struct {
+	Name       string            `xml:"name,attr"`
+	CompFilter *caldavCompFilter `xml:"C:comp-filter,omitempty"`
+	TimeRange  *struct {
+		Start string `xml:"start,attr"`
+		End   string `xml:"end,attr"`
+	} `xml:"C:time-range,omitempty"`
+	PropFilter *struct {
+		Name      string `xml:"name,attr"`
+		TextMatch string `xml:"C:text-match"`
+	} `xml:"C:prop-filter,omitempty"`
+}
+
+type caldavMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"DAV: href"`
+		Propstat []struct {
+			Status string `xml:"DAV: status"`
+			Prop   struct {
+				ETag         string `xml:"DAV: getetag"`
+				CalendarData string `xml:"urn:ietf:params:xml:ns:caldav calendar-data"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+func newCalDAVEventQuery() (*caldavCalendarQuery, *caldavCompFilter) {
+	query := &caldavCalendarQuery{
+		XmlnsD: "DAV:",
+		XmlnsC: "urn:ietf:params:xml:ns:caldav",
+	}
+	query.Filter.Name = "VCALENDAR"
+	query.Filter.CompFilter = &caldavCompFilter{Name: "VEVENT"}
+	return query, query.Filter.CompFilter
+}
+
+func (t *caldavTarget) List(ctx context.Context, start, end time.Time) ([]*calendar.Event, error) {
+	query, vevent := newCalDAVEventQuery()
+	vevent.TimeRange = &struct {
+		Start string `xml:"start,attr"`
+		End   string `xml:"end,attr"`
+	}{
+		Start: start.UTC().Format(icsTimeFormat) + "Z",
+		End:   end.UTC().Format(icsTimeFormat) + "Z",
+	}
+
+	return t.report(ctx, query)
+}
+
+func (t *caldavTarget) FindBySourceID(ctx context.Context, epexprs ...string) (*calendar.Event, error) {
+	if len(epexprs) == 0 {
+		return nil, nil
+	}
+
+	query, vevent := newCalDAVEventQuery()
+	vevent.PropFilter = &struct {
+		Name      string `xml:"name,attr"`
+		TextMatch string `xml:"C:text-match"`
+	}{
+		Name:      "X-GRN2GCAL-PRIVATE",
+		TextMatch: epexprs[0],
+	}
+
+	events, err := t.report(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	// text-match is a substring match
+	return selectGcalEventByKeys(events, epexprs...), nil
+}
+
+func (t *caldavTarget) Insert(ctx context.Context, event *calendar.Event) error {
+	uid := "garoon"
+	for _, k := range []string{gcalEPKeyGaroonEventID, gcalEPKeyGaroonCandidate} {
+		if v, found := event.ExtendedProperties.Private[k]; found {
+			uid += "-" + v
+		}
+	}
+
+	href := t.URL.ResolveReference(&url.URL{Path: url.PathEscape(uid + ".ics")})
+	header := http.Header{}
+	header.Set("If-None-Match", "*")
+
+	return t.put(ctx, href.String(), uid+"@grn2gcal", event, header)
+}
+
+func (t *caldavTarget) Update(ctx context.Context, event *calendar.Event) error {
+	header := http.Header{}
+	if event.Etag != "" {
+		header.Set("If-Match", event.Etag)
+	}
+
+	return t.put(ctx, t.resolve(event.Id), event.ICalUID, event, header)
+}
+
+func (t *caldavTarget) Delete(ctx context.Context, event *calendar.Event) error {
+	req, err := t.newRequest(ctx, http.MethodDelete, t.resolve(event.Id), nil)
+	if err != nil {
+		return err
+	}
+	if event.Etag != "" {
+		req.Header.Set("If-Match", event.Etag)
+	}
+
+	_, err = t.do(req)
+	return err
+}
+
+// PUT a VCALENDAR holding an event
+func (t *caldavTarget) put(ctx context.Context, href, uid string, event *calendar.Event, header http.Header) error {
+	if event.Start == nil || event.End == nil {
+		return fmt.Errorf("CalDAV event %s has no start or end", uid)
+	}
+
+	cal := newICSCalendar(time.Now())
+	cal.extendedProperties = true
+	cal.addEvent(newICSEvent(uid, event, event.Start.TimeZone))
+
+	var body bytes.Buffer
+	if _, err := cal.WriteTo(&body); err != nil {
+		return err
+	}
+
+	req, err := t.newRequest(ctx, http.MethodPut, href, &body)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "text/calendar; charset=utf-8")
+
+	_, err = t.do(req)
+	return err
+}
+
+// REPORT a calendar-query
+func (t *caldavTarget) report(ctx context.Context, query *caldavCalendarQuery) ([]*calendar.Event, error) {
+	marshaled, err := xml.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := t.newRequest(ctx, "REPORT", t.URL.String(), bytes.NewReader(append([]byte(xml.Header), marshaled...)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	body, err := t.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var multistatus caldavMultistatus
+	if err := xml.Unmarshal(body, &multistatus); err != nil {
+		return nil, err
+	}
+
+	var events []*calendar.Event
+	for _, r := range multistatus.Responses {
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200 ") || ps.Prop.CalendarData == "" {
+				continue
+			}
+
+			parsed, err := parseICSEvents(ps.Prop.CalendarData)
+			if err != nil {
+				log.Printf("Failed to parse a CalDAV event(%v): %v\n", r.Href, err)
+				continue
+			}
+			for _, ev := range parsed {
+				ev.Id = r.Href
+				ev.Etag = ps.Prop.ETag
+				events = append(events, ev)
+			}
+		}
+	}
+	return events, nil
+}
+
+func (t *caldavTarget) resolve(href string) string {
+	u, err := url.Parse(href)
+	if err != nil {
+		return href
+	}
+	return t.URL.ResolveReference(u).String()
+}
+
+func (t *caldavTarget) newRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if t.Username != "" {
+		req.SetBasicAuth(t.Username, t.Password)
+	}
+	return req, nil
+}
+
+func (t *caldavTarget) do(req *http.Request) ([]byte, error) {
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || 300 <= resp.StatusCode {
+		return nil, fmt.Errorf("CalDAV %s %s: %s", req.Method, req.URL, resp.Status)
+	}
+	return body, nil
+}
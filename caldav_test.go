@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// caldavStub ...
+// a CalDAV collection in memory.
+// REPORT returns every resource matching a prop-filter text-match, ignoring time-range.
+type caldavStub struct {
+	mu        sync.Mutex
+	resources map[string]string // path -> VCALENDAR
+	etags     map[string]string
+	version   int
+}
+
+func newCalDAVStub(t *testing.T) (*caldavStub, *httptest.Server) {
+	t.Helper()
+
+	stub := &caldavStub{resources: map[string]string{}, etags: map[string]string{}}
+	server := httptest.NewServer(http.HandlerFunc(stub.serveHTTP))
+	t.Cleanup(server.Close)
+	return stub, server
+}
+
+func (s *caldavStub) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	etag := s.etags[r.URL.Path]
+
+	switch r.Method {
+	case "REPORT":
+		var query struct {
+			TextMatch string `xml:"filter>comp-filter>comp-filter>prop-filter>text-match"`
+		}
+		if err := xml.Unmarshal(body, &query); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		paths := make([]string, 0, len(s.resources))
+		for path := range s.resources {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><D:multistatus xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">`)
+		for _, path := range paths {
+			if !strings.Contains(s.resources[path], query.TextMatch) {
+				continue
+			}
+			fmt.Fprintf(w, `<D:response><D:href>%s</D:href><D:propstat><D:prop><D:getetag>%s</D:getetag><C:calendar-data>%s</C:calendar-data></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>`,
+				html.EscapeString(path), html.EscapeString(s.etags[path]), html.EscapeString(s.resources[path]))
+		}
+		fmt.Fprint(w, `</D:multistatus>`)
+
+	case http.MethodPut:
+		if r.Header.Get("If-None-Match") == "*" && etag != "" {
+			http.Error(w, "exists", http.StatusPreconditionFailed)
+			return
+		}
+		if m := r.Header.Get("If-Match"); m != "" && m != etag {
+			http.Error(w, "modified", http.StatusPreconditionFailed)
+			return
+		}
+		s.version++
+		s.resources[r.URL.Path] = string(body)
+		s.etags[r.URL.Path] = fmt.Sprintf(`"%d"`, s.version)
+		w.WriteHeader(http.StatusCreated)
+
+	case http.MethodDelete:
+		if etag == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if m := r.Header.Get("If-Match"); m != "" && m != etag {
+			http.Error(w, "modified", http.StatusPreconditionFailed)
+			return
+		}
+		delete(s.resources, r.URL.Path)
+		delete(s.etags, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, r.Method, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestCalDAVTarget(t *testing.T) {
+	stub, server := newCalDAVStub(t)
+	target := newCalDAVTarget(&CalDAVConfig{URL: server.URL + "/calendars/user/garoon"})
+	ctx := context.Background()
+	start, end := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	var temporary, allday ScheduleGetEventsResult
+	loadGaroonFixture(t, "ScheduleGetEventsResponse_temporary.xml", &temporary)
+	loadGaroonFixture(t, "ScheduleGetEventsResponse_allday.xml", &allday)
+	syncConfig := &SyncConfig{}
+
+	// insert
+	var inserted []calendar.Event
+	for _, grnEvent := range []*GaroonEvent{temporary.Events[0], temporary.Events[1], allday.Events[0], allday.Events[1]} {
+		gcalEvents := convertFixtureIntoGcalEvents(t, grnEvent, syncConfig)
+		for i := range gcalEvents {
+			if err := target.Insert(ctx, &gcalEvents[i]); err != nil {
+				t.Fatalf("Insert %v: %v", gcalEventKeys(&gcalEvents[i]), err)
+			}
+		}
+		inserted = append(inserted, gcalEvents...)
+	}
+	if err := target.Insert(ctx, &inserted[0]); err == nil {
+		t.Error("Insert twice: no error")
+	}
+
+	// list
+	listed, err := target.List(ctx, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != len(inserted) || len(stub.resources) != len(inserted) {
+		t.Fatalf("len = %d (%d resources), want %d", len(listed), len(stub.resources), len(inserted))
+	}
+	for i := range inserted {
+		keys := gcalEventKeys(&inserted[i])
+		found := selectGcalEventByKeys(listed, keys...)
+		if found == nil {
+			t.Errorf("%v: not listed", keys)
+			continue
+		}
+		if equal, cause := isEqualGcalEvent(&inserted[i], found); !equal {
+			t.Errorf("%v: changed through CalDAV (%s)", keys, cause)
+		}
+	}
+
+	// find
+	candidate, err := target.FindBySourceID(ctx, gcalEventKeys(&inserted[1])...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if candidate == nil || candidate.ExtendedProperties.Private[gcalEPKeyGaroonCandidate] != "1" {
+		t.Fatalf("FindBySourceID = %v, want candidate 1", candidate)
+	}
+
+	// update
+	candidate.Summary = "<会議>: 変更"
+	if err := target.Update(ctx, candidate); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := target.FindBySourceID(ctx, gcalEventKeys(&inserted[1])...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated == nil || updated.Summary != "<会議>: 変更" {
+		t.Fatalf("updated = %v", updated)
+	}
+	if err := target.Update(ctx, candidate); err == nil {
+		t.Error("Update with a stale ETag: no error")
+	}
+
+	// delete
+	if err := target.Delete(ctx, updated); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := target.FindBySourceID(ctx, gcalEventKeys(&inserted[1])...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != nil {
+		t.Errorf("deleted = %v", deleted)
+	}
+	if len(stub.resources) != len(inserted)-1 {
+		t.Errorf("%d resources left, want %d", len(stub.resources), len(inserted)-1)
+	}
+}
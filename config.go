@@ -3,7 +3,13 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 )
 
 // Config ...
@@ -11,6 +17,54 @@ import (
 type Config struct {
 	Garoon GaroonConfig `json:"garoon"`
 	Gcal   GcalConfig   `json:"gcal"`
+	Sync   SyncConfig   `json:"sync"`
+	Serve  ServeConfig  `json:"serve"`
+
+	// (optional) sync into a CalDAV calendar instead of Gcal
+	CalDAV CalDAVConfig `json:"caldav"`
+
+	// (optional) users to sync in a run.
+	// no profiles: a user of garoon and gcal configs.
+	Profiles []ProfileConfig `json:"profiles"`
+}
+
+// ProfileConfig ...
+// a user to sync, overriding garoon and gcal configs
+type ProfileConfig struct {
+	// a name to distinguish profiles
+	Name string `json:"name"`
+
+	// (optional) Garoon account name.
+	Account string `json:"account"`
+
+	// (optional) Garoon password.
+	Password string `json:"password"`
+
+	// (optional) a Gcal user impersonated by gcal.service_account_key
+	Subject string `json:"subject"`
+
+	// (optional) a Gcal calendar to sync into
+	CalendarID string `json:"calendar_id"`
+}
+
+// ApplyProfile ...
+// returns a copy of the config overridden by a profile
+func (c *Config) ApplyProfile(profile *ProfileConfig) Config {
+	applied := *c
+	applied.Profiles = nil
+
+	if profile.Account != "" {
+		applied.Garoon.Account = profile.Account
+		applied.Garoon.Password = profile.Password
+	}
+	if profile.Subject != "" {
+		applied.Gcal.Subject = profile.Subject
+	}
+	if profile.CalendarID != "" {
+		applied.Gcal.CalendarID = profile.CalendarID
+	}
+
+	return applied
 }
 
 // GaroonConfig ...
@@ -24,6 +78,91 @@ type GaroonConfig struct {
 
 	// (optional) password.
 	Password string `json:"password"`
+
+	// (optional) how to authenticate.
+	// "password"(default): UsernameToken in SOAP headers,
+	// "session": login form and a session cookie,
+	// "cookie": session_cookie
+	Auth string `json:"auth"`
+
+	// (optional) a session cookie obtained elsewhere for auth=cookie.
+	// "NAME=VALUE"
+	SessionCookie string `json:"session_cookie"`
+
+	// (optional) HTTP Basic auth in front of Garoon (e.g. cybozu.com)
+	BasicAuthUser     string `json:"basic_auth_user"`
+	BasicAuthPassword string `json:"basic_auth_password"`
+
+	// (optional) send X-Cybozu-Authorization made of account and password
+	CybozuAuthorization bool `json:"cybozu_authorization"`
+
+	// (optional) headers added to every request
+	Headers map[string]string `json:"headers"`
+
+	// (optional) timeout of a request.
+	// default: "60s"
+	Timeout string `json:"timeout"`
+
+	// (optional) locale of Garoon responses: "ja"(default), "en" or "zh"
+	Locale string `json:"locale"`
+
+	// (optional) how long a SOAP request is valid.
+	// default: "10m"
+	TimestampValidity string `json:"timestamp_validity"`
+
+	// (optional) proxy url. default: HTTP_PROXY or HTTPS_PROXY
+	ProxyURL string `json:"proxy_url"`
+
+	// (optional) PEM file of CA certificates to trust in addition to the system ones.
+	CACertFile string `json:"ca_cert_file"`
+
+	// (optional) a client certificate.
+	// PEM (with client_key_file) or PKCS#12 (.pfx, .p12, with client_cert_password)
+	ClientCertFile     string `json:"client_cert_file"`
+	ClientKeyFile      string `json:"client_key_file"`
+	ClientCertPassword string `json:"client_cert_password"`
+
+	// (optional) read events from a schedule CSV exported from Garoon, instead of the API.
+	// a directory: the newest *.csv in it.
+	// CSV has no event IDs; a rescheduled or renamed event is deleted and added again.
+	CSVFile string `json:"csv_file"`
+
+	// (optional) time zone of times in csv_file. default: local
+	TimeZone string `json:"time_zone"`
+}
+
+// IsPKCS12ClientCert ...
+// whether client_cert_file is a .pfx or .p12 file
+func (c *GaroonConfig) IsPKCS12ClientCert() bool {
+	ext := strings.ToLower(filepath.Ext(c.ClientCertFile))
+	return ext == ".pfx" || ext == ".p12"
+}
+
+// GetLocale ...
+// returns the Locale of a SOAP header, "jp", "en" or "zh"
+func (c *GaroonConfig) GetLocale() string {
+	switch c.Locale {
+	case "", "ja", "jp":
+		return "jp"
+	}
+	return c.Locale
+}
+
+// default of garoon.timeout
+const defaultGaroonTimeout = 60 * time.Second
+
+// GetTimeout ...
+// returns timeout or its default
+func (c *GaroonConfig) GetTimeout() time.Duration {
+	if c.Timeout == "" {
+		return defaultGaroonTimeout
+	}
+
+	d, err := time.ParseDuration(c.Timeout)
+	if err != nil {
+		return defaultGaroonTimeout
+	}
+	return d
 }
 
 // GcalConfig ...
@@ -31,6 +170,282 @@ type GaroonConfig struct {
 type GcalConfig struct {
 	ClientID     string `json:"client_id"`
 	ClientSecret string `json:"client_secret"`
+
+	// (optional) path to a JSON key of a service account.
+	// used instead of client_id and client_secret.
+	ServiceAccountKey string `json:"service_account_key"`
+
+	// (optional) a user impersonated by the service account.
+	// needs domain-wide delegation.
+	Subject string `json:"subject"`
+
+	// (optional) a calendar to sync into.
+	// default: the primary calendar
+	CalendarID string `json:"calendar_id"`
+
+	// (optional) "auto"(default), "browser", "device" or "paste".
+	// --auth-mode overrides.
+	AuthMode string `json:"auth_mode"`
+
+	// (optional) how long to wait for an authorization.
+	// default: "5m"
+	AuthTimeout string `json:"auth_timeout"`
+
+	// (optional) do not open a browser, only show the URL.
+	// --no-browser overrides.
+	NoBrowser bool `json:"no_browser"`
+
+	// authorize again even if a token is cached (--reauth)
+	Reauth bool `json:"-"`
+}
+
+// default of gcal.auth_timeout
+const defaultAuthTimeout = 5 * time.Minute
+
+// GetAuthTimeout ...
+// returns auth_timeout or its default
+func (c *GcalConfig) GetAuthTimeout() time.Duration {
+	if c.AuthTimeout == "" {
+		return defaultAuthTimeout
+	}
+
+	d, err := time.ParseDuration(c.AuthTimeout)
+	if err != nil {
+		return defaultAuthTimeout
+	}
+	return d
+}
+
+// CalDAVConfig ...
+// a CalDAV calendar to sync into
+type CalDAVConfig struct {
+	// a calendar collection URL, e.g. "https://nextcloud.example.com/remote.php/dav/calendars/user/personal/"
+	URL string `json:"url"`
+
+	// (optional) user name of Basic authentication
+	Username string `json:"username"`
+
+	// (optional) password of Basic authentication
+	Password string `json:"password"`
+}
+
+// ServeConfig ...
+// a config of the serve command
+type ServeConfig struct {
+	// (optional) an address to listen. default: ":8080"
+	Addr string `json:"addr"`
+
+	// a token required as ?token=... in subscription URLs
+	Token string `json:"token"`
+
+	// (optional) how often Garoon events are fetched. default: "15m"
+	CacheInterval string `json:"cache_interval"`
+}
+
+// default of serve.addr
+const defaultServeAddr = ":8080"
+
+// default of serve.cache_interval
+const defaultCacheInterval = 15 * time.Minute
+
+// GetAddr ...
+// returns addr or its default
+func (c *ServeConfig) GetAddr() string {
+	if c.Addr == "" {
+		return defaultServeAddr
+	}
+	return c.Addr
+}
+
+// GetCacheInterval ...
+// returns cache_interval or its default
+func (c *ServeConfig) GetCacheInterval() time.Duration {
+	if c.CacheInterval == "" {
+		return defaultCacheInterval
+	}
+
+	d, err := time.ParseDuration(c.CacheInterval)
+	if err != nil {
+		return defaultCacheInterval
+	}
+	return d
+}
+
+// SyncConfig ...
+// a config to control how Garoon events are mirrored
+type SyncConfig struct {
+	// (optional) how to handle private Garoon events.
+	// "copy"(default), "private", "busy" or "skip"
+	PrivateEvent string `json:"private_event"`
+
+	// (optional) how to end Garoon events without end time.
+	// "duration"(default) or "point"
+	StartOnlyMode string `json:"start_only_mode"`
+
+	// (optional) duration of start-only events in "duration" mode.
+	// default: "30m"
+	StartOnlyDuration string `json:"start_only_duration"`
+
+	// (optional) reminders of mirrored events.
+	// the first matching rule applies. no match: the calendar's default.
+	Reminders []ReminderRule `json:"reminders"`
+
+	// (optional) free/busy of mirrored events.
+	// the first matching rule applies. no match: busy.
+	Transparency []TransparencyRule `json:"transparency"`
+
+	// (optional) number of the newest follow-ups appended to descriptions.
+	// default: 0 (none)
+	Follows int `json:"follows"`
+
+	// (optional) how to show attachments of Garoon events.
+	// "off"(default), "description" (links in descriptions) or "gcal" (Gcal attachments).
+	// Gcal attachments must be Google Drive files; others are linked in descriptions even with "gcal".
+	Attachments string `json:"attachments"`
+
+	// (optional) how to resolve mirrored events edited in Gcal.
+	// "garoon"(default), "google" or "skip"
+	Conflict string `json:"conflict"`
+
+	// (optional) what to do when a mirrored event is deleted or declined in Gcal.
+	// "off"(default), "report" or "leave" (leave the Garoon event)
+	Decline string `json:"decline"`
+
+	// (optional) sync from Gcal to Garoon
+	Reverse ReverseConfig `json:"reverse"`
+}
+
+// ReverseConfig ...
+// Gcal events to be added to Garoon
+type ReverseConfig struct {
+	// (optional) add Gcal events to Garoon. default: false
+	Enabled bool `json:"enabled"`
+
+	// (optional) events whose title contains the tag, e.g. "#garoon".
+	// the tag is stripped from the Garoon title.
+	Tag string `json:"tag"`
+
+	// (optional) every event in this calendar.
+	// added events are moved to the sync calendar.
+	CalendarID string `json:"calendar_id"`
+
+	// (optional) plan (menu) of added events
+	Plan string `json:"plan"`
+
+	// (optional) time zone of added events. default: the calendar's
+	TimeZone string `json:"time_zone"`
+}
+
+// EventRule ...
+// conditions to select Garoon events.
+// empty conditions match every event.
+type EventRule struct {
+	// (optional) plan (menu) of an event
+	Plan string `json:"plan,omitempty"`
+
+	// (optional) all-day events or not
+	AllDay *bool `json:"all_day,omitempty"`
+
+	// (optional) regular expression for a title
+	Title string `json:"title,omitempty"`
+}
+
+// ReminderRule ...
+// reminders of events matching a rule
+type ReminderRule struct {
+	EventRule
+
+	// use the calendar's default reminders
+	UseDefault bool `json:"use_default"`
+
+	// reminders. empty: no reminders
+	Overrides []Reminder `json:"overrides"`
+}
+
+// TransparencyRule ...
+// free/busy of events matching a rule
+type TransparencyRule struct {
+	EventRule
+
+	// "opaque"(busy) or "transparent"(free)
+	Transparency string `json:"transparency"`
+}
+
+// Reminder ...
+// a reminder before an event
+type Reminder struct {
+	// "popup" or "email"
+	Method string `json:"method"`
+
+	// minutes before an event
+	Minutes int64 `json:"minutes"`
+}
+
+// private event policies
+const (
+	// copy as-is
+	PrivateEventCopy string = "copy"
+	// copy with visibility=private
+	PrivateEventPrivate string = "private"
+	// copy with the title replaced with "Busy"
+	PrivateEventBusy string = "busy"
+	// do not copy
+	PrivateEventSkip string = "skip"
+)
+
+// start-only event modes
+const (
+	// end after sync.start_only_duration
+	StartOnlyModeDuration string = "duration"
+	// end at start, not blocking free/busy
+	StartOnlyModePoint string = "point"
+)
+
+// attachment modes
+const (
+	// do not show
+	AttachmentsOff string = "off"
+	// links in descriptions
+	AttachmentsDescription string = "description"
+	// Gcal attachments
+	AttachmentsGcal string = "gcal"
+)
+
+// conflict policies
+const (
+	// overwrite the Gcal event
+	ConflictGaroon string = "garoon"
+	// overwrite the Garoon event
+	ConflictGoogle string = "google"
+	// leave both and report
+	ConflictSkip string = "skip"
+)
+
+// decline policies
+const (
+	// re-create the Gcal event
+	DeclineOff string = "off"
+	// report, and do not re-create the Gcal event
+	DeclineReport string = "report"
+	// leave the Garoon event
+	DeclineLeave string = "leave"
+)
+
+// default of sync.start_only_duration
+const defaultStartOnlyDuration = 30 * time.Minute
+
+// GetStartOnlyDuration ...
+// returns start_only_duration or its default
+func (c *SyncConfig) GetStartOnlyDuration() time.Duration {
+	if c.StartOnlyDuration == "" {
+		return defaultStartOnlyDuration
+	}
+
+	d, err := time.ParseDuration(c.StartOnlyDuration)
+	if err != nil {
+		return defaultStartOnlyDuration
+	}
+	return d
 }
 
 // NewConfig ...
@@ -68,11 +483,200 @@ func CreateConfigTemplate(filename string) error {
 // ValidateConfig ...
 // validate contents of a config
 func ValidateConfig(config *Config) error {
-	if config.Gcal.ClientID == "" {
-		return errors.New("config validattion error: gcal.client_id is missing")
+	if config.Garoon.Timeout != "" {
+		if d, err := time.ParseDuration(config.Garoon.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("config validattion error: garoon.timeout %q is invalid", config.Garoon.Timeout)
+		}
+	}
+	switch config.Garoon.Auth {
+	case "", GaroonAuthPassword, GaroonAuthSession:
+	case GaroonAuthCookie:
+		if config.Garoon.SessionCookie == "" {
+			return errors.New("config validattion error: garoon.session_cookie is missing")
+		}
+	default:
+		return fmt.Errorf("config validattion error: garoon.auth %q is invalid", config.Garoon.Auth)
+	}
+
+	switch config.Garoon.Locale {
+	case "", "ja", "jp", "en", "zh":
+	default:
+		return fmt.Errorf("config validattion error: garoon.locale %q is invalid", config.Garoon.Locale)
+	}
+	if config.Garoon.TimestampValidity != "" {
+		if d, err := time.ParseDuration(config.Garoon.TimestampValidity); err != nil || d <= 0 {
+			return fmt.Errorf("config validattion error: garoon.timestamp_validity %q is invalid", config.Garoon.TimestampValidity)
+		}
+	}
+	if config.Garoon.ClientCertFile == "" && config.Garoon.ClientKeyFile != "" {
+		return errors.New("config validattion error: garoon.client_cert_file is missing")
 	}
-	if config.Gcal.ClientSecret == "" {
-		return errors.New("config validattion error: gcal.client_secret is missing")
+	if config.Garoon.ClientCertFile != "" && !config.Garoon.IsPKCS12ClientCert() && config.Garoon.ClientKeyFile == "" {
+		return errors.New("config validattion error: garoon.client_key_file is missing")
+	}
+
+	names := make(map[string]bool)
+	for i, p := range config.Profiles {
+		if p.Name == "" {
+			return fmt.Errorf("config validattion error: profiles[%d].name is missing", i)
+		}
+		if names[p.Name] {
+			return fmt.Errorf("config validattion error: profiles[%d].name %q is duplicated", i, p.Name)
+		}
+		names[p.Name] = true
+
+		if p.Subject != "" && config.Gcal.ServiceAccountKey == "" {
+			return fmt.Errorf("config validattion error: profiles[%d].subject needs gcal.service_account_key", i)
+		}
+	}
+
+	switch config.Gcal.AuthMode {
+	case "", AuthModeAuto, AuthModeBrowser, AuthModeDevice, AuthModePaste:
+	default:
+		return fmt.Errorf("config validattion error: gcal.auth_mode %q is invalid", config.Gcal.AuthMode)
+	}
+	if config.Gcal.AuthTimeout != "" {
+		if d, err := time.ParseDuration(config.Gcal.AuthTimeout); err != nil || d <= 0 {
+			return fmt.Errorf("config validattion error: gcal.auth_timeout %q is invalid", config.Gcal.AuthTimeout)
+		}
+	}
+
+	switch config.Sync.PrivateEvent {
+	case "", PrivateEventCopy, PrivateEventPrivate, PrivateEventBusy, PrivateEventSkip:
+	default:
+		return fmt.Errorf("config validattion error: sync.private_event %q is invalid", config.Sync.PrivateEvent)
+	}
+
+	switch config.Sync.StartOnlyMode {
+	case "", StartOnlyModeDuration, StartOnlyModePoint:
+	default:
+		return fmt.Errorf("config validattion error: sync.start_only_mode %q is invalid", config.Sync.StartOnlyMode)
+	}
+
+	if config.Sync.Follows < 0 {
+		return fmt.Errorf("config validattion error: sync.follows %d is out of range", config.Sync.Follows)
+	}
+
+	switch config.Sync.Attachments {
+	case "", AttachmentsOff, AttachmentsDescription, AttachmentsGcal:
+	default:
+		return fmt.Errorf("config validattion error: sync.attachments %q is invalid", config.Sync.Attachments)
+	}
+
+	switch config.Sync.Conflict {
+	case "", ConflictGaroon, ConflictGoogle, ConflictSkip:
+	default:
+		return fmt.Errorf("config validattion error: sync.conflict %q is invalid", config.Sync.Conflict)
+	}
+
+	switch config.Sync.Decline {
+	case "", DeclineOff, DeclineReport, DeclineLeave:
+	default:
+		return fmt.Errorf("config validattion error: sync.decline %q is invalid", config.Sync.Decline)
+	}
+	if config.Sync.StartOnlyDuration != "" {
+		if d, err := time.ParseDuration(config.Sync.StartOnlyDuration); err != nil || d <= 0 {
+			return fmt.Errorf("config validattion error: sync.start_only_duration %q is invalid", config.Sync.StartOnlyDuration)
+		}
+	}
+
+	for i, r := range config.Sync.Reminders {
+		if err := validateEventRule(&r.EventRule); err != nil {
+			return fmt.Errorf("config validattion error: sync.reminders[%d]: %v", i, err)
+		}
+		for _, o := range r.Overrides {
+			if o.Method != "popup" && o.Method != "email" {
+				return fmt.Errorf("config validattion error: sync.reminders[%d]: method %q is invalid", i, o.Method)
+			}
+			if o.Minutes < 0 || 40320 < o.Minutes {
+				return fmt.Errorf("config validattion error: sync.reminders[%d]: minutes %d is out of range", i, o.Minutes)
+			}
+		}
+	}
+
+	for i, r := range config.Sync.Transparency {
+		if err := validateEventRule(&r.EventRule); err != nil {
+			return fmt.Errorf("config validattion error: sync.transparency[%d]: %v", i, err)
+		}
+		if r.Transparency != "opaque" && r.Transparency != "transparent" {
+			return fmt.Errorf("config validattion error: sync.transparency[%d]: transparency %q is invalid", i, r.Transparency)
+		}
+	}
+
+	if config.Sync.Reverse.Enabled {
+		if config.Sync.Reverse.Tag == "" && config.Sync.Reverse.CalendarID == "" {
+			return errors.New("config validattion error: sync.reverse needs tag or calendar_id")
+		}
+		if config.Sync.Reverse.TimeZone != "" {
+			if _, err := time.LoadLocation(config.Sync.Reverse.TimeZone); err != nil {
+				return fmt.Errorf("config validattion error: sync.reverse.time_zone %q is invalid", config.Sync.Reverse.TimeZone)
+			}
+		}
+	}
+
+	if config.Garoon.CSVFile != "" {
+		if config.Garoon.TimeZone != "" {
+			if _, err := time.LoadLocation(config.Garoon.TimeZone); err != nil {
+				return fmt.Errorf("config validattion error: garoon.time_zone %q is invalid", config.Garoon.TimeZone)
+			}
+		}
+		if config.Sync.Reverse.Enabled {
+			return errors.New("config validattion error: sync.reverse is not available with garoon.csv_file")
+		}
+		if config.Sync.Decline != "" && config.Sync.Decline != DeclineOff {
+			return errors.New("config validattion error: sync.decline is not available with garoon.csv_file")
+		}
+		if config.Sync.Conflict == ConflictGoogle {
+			return errors.New("config validattion error: sync.conflict \"google\" is not available with garoon.csv_file")
+		}
+	}
+
+	if config.CalDAV.URL != "" {
+		if u, err := url.Parse(config.CalDAV.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("config validattion error: caldav.url %q is invalid", config.CalDAV.URL)
+		}
+		if config.Sync.Reverse.Enabled {
+			return errors.New("config validattion error: sync.reverse is not available with caldav")
+		}
+		if config.Sync.Decline != "" && config.Sync.Decline != DeclineOff {
+			return errors.New("config validattion error: sync.decline is not available with caldav")
+		}
+	}
+
+	if config.Serve.CacheInterval != "" {
+		if d, err := time.ParseDuration(config.Serve.CacheInterval); err != nil || d <= 0 {
+			return fmt.Errorf("config validattion error: serve.cache_interval %q is invalid", config.Serve.CacheInterval)
+		}
+	}
+
+	return nil
+}
+
+// ValidateGcalConfig ...
+// validate contents of a config to log in to Gcal.
+// needed only to sync into Gcal (not CalDAV), unlike export ics or serve.
+func ValidateGcalConfig(config *Config) error {
+	if config.CalDAV.URL != "" {
+		return nil
+	}
+
+	if config.Gcal.ServiceAccountKey == "" {
+		if config.Gcal.ClientID == "" {
+			return errors.New("config validattion error: gcal.client_id is missing")
+		}
+		if config.Gcal.ClientSecret == "" {
+			return errors.New("config validattion error: gcal.client_secret is missing")
+		}
+	}
+
+	return nil
+}
+
+func validateEventRule(rule *EventRule) error {
+	if rule.Title != "" {
+		if _, err := regexp.Compile(rule.Title); err != nil {
+			return fmt.Errorf("title %q is invalid: %v", rule.Title, err)
+		}
 	}
 
 	return nil
@@ -2,14 +2,24 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
-	//"net/http/cookiejar"
-	//"net/url"
-	"text/template"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
+
+	"golang.org/x/crypto/pkcs12"
 )
 
 /////////////////
@@ -22,16 +32,149 @@ type Service struct {
 	Account  string
 	Password string
 	BaseURL  string
+	Client   *http.Client
+
+	// Locale of the SOAP header ("jp", "en" or "zh")
+	Locale string
+	// Expires - Created of the SOAP header
+	TimestampValidity time.Duration
+
+	// UsernameToken, a session or a cookie
+	Auth GaroonAuth
 }
 
+// defaults of a service
+const (
+	defaultGaroonLocale            string        = "jp"
+	defaultGaroonTimestampValidity time.Duration = 10 * time.Minute
+)
+
 // NewGaroon ...
-// creates a service instance
-func NewGaroon(account, password, BaseURL string) *Service {
+// creates a service instance.
+// client may be nil to use http.DefaultClient.
+func NewGaroon(account, password, BaseURL string, client *http.Client) *Service {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
 	return &Service{
 		Account:  account,
 		Password: password,
 		BaseURL:  BaseURL,
+		Client:   client,
+
+		Locale:            defaultGaroonLocale,
+		TimestampValidity: defaultGaroonTimestampValidity,
+
+		Auth: &GaroonUsernameTokenAuth{},
+	}
+}
+
+// NewGaroonHTTPClient ...
+// creates an http client for Garoon with timeout, proxy and TLS settings
+func NewGaroonHTTPClient(config *GaroonConfig) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if config.ProxyURL != "" {
+		proxyURL, err := url.Parse(config.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse garoon.proxy_url: %v", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig := &tls.Config{}
+	if config.CACertFile != "" {
+		caPEM, err := ioutil.ReadFile(config.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read garoon.ca_cert_file: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("No certificates in garoon.ca_cert_file %s", config.CACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.ClientCertFile != "" {
+		cert, err := loadClientCertificate(config)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load garoon.client_cert_file: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	// for GaroonSession
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create cookie jar.\n... %v", err)
+	}
+
+	headers := make(map[string]string)
+	for k, v := range config.Headers {
+		headers[k] = v
+	}
+	if config.CybozuAuthorization {
+		headers["X-Cybozu-Authorization"] = base64.StdEncoding.EncodeToString([]byte(config.Account + ":" + config.Password))
+	}
+
+	return &http.Client{
+		Transport: &garoonTransport{
+			base:          transport,
+			basicUser:     config.BasicAuthUser,
+			basicPassword: config.BasicAuthPassword,
+			headers:       headers,
+		},
+		Timeout: config.GetTimeout(),
+		Jar:     jar,
+	}, nil
+}
+
+// PEM cert+key, or PKCS#12 (.pfx, .p12)
+func loadClientCertificate(config *GaroonConfig) (tls.Certificate, error) {
+	if !config.IsPKCS12ClientCert() {
+		return tls.LoadX509KeyPair(config.ClientCertFile, config.ClientKeyFile)
+	}
+
+	data, err := ioutil.ReadFile(config.ClientCertFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	blocks, err := pkcs12.ToPEM(data, config.ClientCertPassword)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	var pemData []byte
+	for _, b := range blocks {
+		pemData = append(pemData, pem.EncodeToMemory(b)...)
+	}
+	return tls.X509KeyPair(pemData, pemData)
+}
+
+// garoonTransport ...
+// adds Basic auth and extra headers to every Garoon request
+type garoonTransport struct {
+	base http.RoundTripper
+
+	basicUser, basicPassword string
+	headers                  map[string]string
+}
+
+func (t *garoonTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+
+	if t.basicUser != "" {
+		req.SetBasicAuth(t.basicUser, t.basicPassword)
 	}
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+
+	return t.base.RoundTrip(req)
 }
 
 // SOAP api paths
@@ -58,34 +201,35 @@ const (
 
 // ScheduleGetEvents, ScheduleGetEventsById
 
+// Garoon event types (event_type)
+const (
+	GaroonEventTypeNormal    string = "normal"
+	GaroonEventTypeRepeat    string = "repeat"
+	GaroonEventTypeTemporary string = "temporary" // candidates in when>datetime
+	GaroonEventTypeBanner    string = "banner"    // spans several days
+)
+
 // GaroonEvent ...
 // an api result
 type GaroonEvent struct {
-	_           xml.Name `xml:"schedule_event"`
-	ID          string   `xml:"id,attr"`
-	EventType   string   `xml:"event_type,attr"`
-	Plan        string   `xml:"plan,attr"`
-	Detail      string   `xml:"detail,attr"`
-	Description string   `xml:"description,attr"`
-	TimeZone    string   `xml:"timezone,attr"`
-	EndTimeZone string   `xml:"end_timezone,attr"`
-	StartOnly   bool     `xml:"start_only,attr"`
-	Datetime    []*struct {
-		_     xml.Name `xml:"datetime"`
-		Start string   `xml:"start,attr"`
-		End   string   `xml:"end,attr"`
-	} `xml:"when>datetime"`
-	Date []*struct {
-		_     xml.Name `xml:"date"`
-		Start string   `xml:"start,attr"`
-		End   string   `xml:"end,attr"`
-	} `xml:"when>date"`
-	Members []*struct {
-		_    xml.Name `xml:"user"`
-		ID   string   `xml:"id,attr"`
-		Name string   `xml:"name,attr"`
-	} `xml:"members>member>user"`
-	Repeat *struct {
+	_             xml.Name        `xml:"schedule_event"`
+	ID            string          `xml:"id,attr"`
+	EventType     string          `xml:"event_type,attr"`
+	PublicType    string          `xml:"public_type,attr"`
+	Plan          string          `xml:"plan,attr"`
+	Detail        string          `xml:"detail,attr"`
+	Description   string          `xml:"description,attr"`
+	TimeZone      string          `xml:"timezone,attr"`
+	EndTimeZone   string          `xml:"end_timezone,attr"`
+	StartOnly     bool            `xml:"start_only,attr"`
+	AllDay        bool            `xml:"allday,attr"`
+	Version       string          `xml:"version,attr"`
+	Datetime      []*GaroonSpan   `xml:"when>datetime"`
+	Date          []*GaroonSpan   `xml:"when>date"`
+	Members       []*GaroonMember `xml:"members>member>user"`
+	Organizations []*GaroonMember `xml:"members>member>organization"`
+	Facilities    []*GaroonMember `xml:"members>member>facility"`
+	Repeat        *struct {
 		Condition *struct {
 			_         xml.Name `xml:"condition"`
 			Type      string   `xml:"type,attr"`
@@ -100,8 +244,54 @@ type GaroonEvent struct {
 			_     xml.Name `xml:"exclusive_datetime"`
 			Start string   `xml:"start,attr"`
 			End   string   `xml:"end,attr"`
-		} `xml:"exclusive"`
+		} `xml:"exclusive_datetimes>exclusive_datetime"`
 	} `xml:"repeat_info"`
+	Follows []*GaroonFollow `xml:"follows>follow"`
+	Files   []*GaroonFile   `xml:"files>file"`
+}
+
+// GaroonMember ...
+// a user, an organization or a facility (such as a meeting room) in members of an event
+type GaroonMember struct {
+	ID   string `xml:"id,attr"`
+	Name string `xml:"name,attr"`
+}
+
+// GaroonSpan ...
+// a datetime or a date of an event
+type GaroonSpan struct {
+	Start string `xml:"start,attr"`
+	End   string `xml:"end,attr"`
+}
+
+// GaroonFile ...
+// an attachment of an event
+type GaroonFile struct {
+	ID       string `xml:"id,attr"`
+	Name     string `xml:"name,attr"`
+	Size     int64  `xml:"size,attr"`
+	MimeType string `xml:"mime_type,attr"`
+
+	// download URL; filled by FileDownloadURL
+	URL string `xml:"-"`
+}
+
+// GaroonFollow ...
+// a follow-up (comment) on an event
+type GaroonFollow struct {
+	ID      string `xml:"follow_id,attr"`
+	Text    string `xml:"text,attr"`
+	Creator struct {
+		UserID string `xml:"user_id,attr"`
+		Name   string `xml:"name,attr"`
+		Date   string `xml:"date,attr"`
+	} `xml:"creator"`
+}
+
+// IsPrivate ...
+// returns true if the event is not open to everyone ("private" or "qualified")
+func (e *GaroonEvent) IsPrivate() bool {
+	return e.PublicType != "" && e.PublicType != "public"
 }
 
 // ScheduleGetEventsResult ...
@@ -118,14 +308,25 @@ type ScheduleGetEventsByIDResult struct {
 	Events []*GaroonEvent `xml:"Body>ScheduleGetEventsByIdResponse>returns>schedule_event"`
 }
 
+type scheduleGetEventsParameters struct {
+	XMLName         xml.Name `xml:"parameters"`
+	Start           string   `xml:"start,attr"`
+	End             string   `xml:"end,attr"`
+	AllRepeatEvents bool     `xml:"all_repeat_events,attr"`
+}
+
 // ScheduleGetEvents ...
 // fetches events between start and end
-func (grn *Service) ScheduleGetEvents(start, end time.Time) (ScheduleGetEventsResult, error) {
+func (grn *Service) ScheduleGetEvents(ctx context.Context, start, end time.Time) (ScheduleGetEventsResult, error) {
 	result := ScheduleGetEventsResult{}
 
-	parameters := fmt.Sprintf(`<parameters start="%s" end="%s" all_repeat_events="true" />`, start.Format(time.RFC3339), end.Format(time.RFC3339))
+	parameters := &scheduleGetEventsParameters{
+		Start:           start.Format(time.RFC3339),
+		End:             end.Format(time.RFC3339),
+		AllRepeatEvents: true,
+	}
 
-	err := grn.callGaroonProc(ScheduleServicePath, "ScheduleGetEvents", parameters, &result)
+	err := grn.callGaroonProc(ctx, ScheduleServicePath, "ScheduleGetEvents", parameters, &result)
 	if err != nil {
 		return result, err
 	}
@@ -134,13 +335,145 @@ func (grn *Service) ScheduleGetEvents(start, end time.Time) (ScheduleGetEventsRe
 }
 
 // ScheduleGetEventsByID ...
-// fetches an event
-func (grn *Service) ScheduleGetEventsByID(eventID string) (ScheduleGetEventsByIDResult, error) {
+// fetches an event; no events if deleted or not open to the user
+func (grn *Service) ScheduleGetEventsByID(ctx context.Context, eventID string) (ScheduleGetEventsByIDResult, error) {
 	result := ScheduleGetEventsByIDResult{}
 
-	parameters := fmt.Sprintf(`<parameters><event_id xmlns="">%s</event_id></parameters>`, eventID)
+	parameters := newEventIDParameters(eventID)
+
+	err := grn.callGaroonProc(ctx, ScheduleServicePath, "ScheduleGetEventsById", parameters, &result)
+	var fault *GaroonFault
+	if errors.As(err, &fault) && fault.IsNotFound() {
+		// no events, as the API returned before faults were decoded
+		return ScheduleGetEventsByIDResult{}, nil
+	}
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// FileDownloadURL ...
+// returns a URL to download an attachment in a browser
+func (grn *Service) FileDownloadURL(eventID, fileID string) string {
+	values := url.Values{}
+	values.Set("event", eventID)
+	values.Set("fid", fileID)
+	return grn.BaseURL + "/schedule/file_download?" + values.Encode()
+}
+
+// ScheduleAddEvents, ScheduleModifyEvents
+
+// GaroonEventParameter ...
+// an event to add or modify
+type GaroonEventParameter struct {
+	XMLName     xml.Name                `xml:"schedule_event"`
+	Xmlns       string                  `xml:"xmlns,attr"`
+	ID          string                  `xml:"id,attr"`
+	EventType   string                  `xml:"event_type,attr"`
+	Version     string                  `xml:"version,attr"`
+	PublicType  string                  `xml:"public_type,attr"`
+	Plan        string                  `xml:"plan,attr,omitempty"`
+	Detail      string                  `xml:"detail,attr"`
+	Description string                  `xml:"description,attr,omitempty"`
+	TimeZone    string                  `xml:"timezone,attr"`
+	EndTimeZone string                  `xml:"end_timezone,attr,omitempty"`
+	AllDay      bool                    `xml:"allday,attr"`
+	StartOnly   bool                    `xml:"start_only,attr"`
+	Members     []GaroonMemberParameter `xml:"members>member"`
+	Datetime    GaroonSpanParameter     `xml:"when>datetime"`
+}
+
+// GaroonMemberParameter ...
+// a member of an event to add or modify; one of a user, an organization or a facility
+type GaroonMemberParameter struct {
+	User         *GaroonIDParameter `xml:"user,omitempty"`
+	Organization *GaroonIDParameter `xml:"organization,omitempty"`
+	Facility     *GaroonIDParameter `xml:"facility,omitempty"`
+}
+
+// GaroonIDParameter ...
+// a reference to a user, an organization or a facility
+type GaroonIDParameter struct {
+	ID string `xml:"id,attr"`
+}
+
+// GaroonSpanParameter ...
+// start and end of an event to add or modify
+type GaroonSpanParameter struct {
+	Start string `xml:"start,attr"`
+	End   string `xml:"end,attr"`
+}
+
+type scheduleEventsParameters struct {
+	XMLName xml.Name                `xml:"parameters"`
+	Events  []*GaroonEventParameter `xml:"schedule_event"`
+}
+
+// ScheduleAddEventsResult ...
+// an api result
+type ScheduleAddEventsResult struct {
+	XMLName xml.Name       `xml:"Envelope"`
+	Events  []*GaroonEvent `xml:"Body>ScheduleAddEventsResponse>returns>schedule_event"`
+}
+
+// ScheduleModifyEventsResult ...
+// an api result
+type ScheduleModifyEventsResult struct {
+	XMLName xml.Name       `xml:"Envelope"`
+	Events  []*GaroonEvent `xml:"Body>ScheduleModifyEventsResponse>returns>schedule_event"`
+}
+
+// ScheduleAddEvents ...
+// adds events. ID and Version of them are "dummy".
+func (grn *Service) ScheduleAddEvents(ctx context.Context, events ...*GaroonEventParameter) (ScheduleAddEventsResult, error) {
+	result := ScheduleAddEventsResult{}
+
+	for _, e := range events {
+		e.ID, e.Version = "dummy", "dummy"
+	}
+	parameters := &scheduleEventsParameters{Events: events}
+
+	err := grn.callGaroonProc(ctx, ScheduleServicePath, "ScheduleAddEvents", parameters, &result)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// ScheduleModifyEvents ...
+// modifies events specified by ID and Version
+func (grn *Service) ScheduleModifyEvents(ctx context.Context, events ...*GaroonEventParameter) (ScheduleModifyEventsResult, error) {
+	result := ScheduleModifyEventsResult{}
+
+	parameters := &scheduleEventsParameters{Events: events}
+
+	err := grn.callGaroonProc(ctx, ScheduleServicePath, "ScheduleModifyEvents", parameters, &result)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// ScheduleLeaveEvents
+
+// ScheduleLeaveEventsResult ...
+// an api result
+type ScheduleLeaveEventsResult struct {
+	XMLName xml.Name `xml:"Envelope"`
+}
+
+// ScheduleLeaveEvents ...
+// removes the login user from participants of events
+func (grn *Service) ScheduleLeaveEvents(ctx context.Context, eventIDs ...string) (ScheduleLeaveEventsResult, error) {
+	result := ScheduleLeaveEventsResult{}
+
+	parameters := newEventIDParameters(eventIDs...)
 
-	err := grn.callGaroonProc(ScheduleServicePath, "ScheduleGetEventsById", parameters, &result)
+	err := grn.callGaroonProc(ctx, ScheduleServicePath, "ScheduleLeaveEvents", parameters, &result)
 	if err != nil {
 		return result, err
 	}
@@ -164,10 +497,10 @@ type UtilGetLoginUserIDResult struct {
 
 // UtilGetLoginUserID ...
 // retrieves the current user id
-func (grn *Service) UtilGetLoginUserID() (UtilGetLoginUserIDResult, error) {
+func (grn *Service) UtilGetLoginUserID(ctx context.Context) (UtilGetLoginUserIDResult, error) {
 	result := UtilGetLoginUserIDResult{}
 
-	err := grn.callGaroonProc(UtilServicePath, "UtilGetLoginUserId", "", &result)
+	err := grn.callGaroonProc(ctx, UtilServicePath, "UtilGetLoginUserId", nil, &result)
 	if err != nil {
 		return result, err
 	}
@@ -175,13 +508,283 @@ func (grn *Service) UtilGetLoginUserID() (UtilGetLoginUserIDResult, error) {
 	return result, nil
 }
 
+////////////////////
+// Authentication //
+////////////////////
+
+// GaroonAuth ...
+// a way to authenticate SOAP requests
+type GaroonAuth interface {
+	// whether the SOAP header contains UsernameToken
+	UsesUsernameToken() bool
+
+	// modifies a request before sending
+	Prepare(ctx context.Context, grn *Service, req *http.Request) error
+}
+
+// Garoon auth types (garoon.auth)
+const (
+	GaroonAuthPassword string = "password"
+	GaroonAuthSession  string = "session"
+	GaroonAuthCookie   string = "cookie"
+)
+
+// NewGaroonAuth ...
+// creates a GaroonAuth of garoon.auth
+func NewGaroonAuth(config *GaroonConfig) GaroonAuth {
+	switch config.Auth {
+	case GaroonAuthSession:
+		return &GaroonSession{}
+	case GaroonAuthCookie:
+		return &GaroonCookieAuth{Cookie: config.SessionCookie}
+	}
+	return &GaroonUsernameTokenAuth{}
+}
+
+// GaroonUsernameTokenAuth ...
+// sends the account and the password in the SOAP header
+type GaroonUsernameTokenAuth struct{}
+
+// UsesUsernameToken ...
+func (a *GaroonUsernameTokenAuth) UsesUsernameToken() bool {
+	return true
+}
+
+// Prepare ...
+func (a *GaroonUsernameTokenAuth) Prepare(ctx context.Context, grn *Service, req *http.Request) error {
+	return nil
+}
+
+// GaroonRenewableAuth ...
+// a GaroonAuth which can log in again when its session expires
+type GaroonRenewableAuth interface {
+	GaroonAuth
+
+	// forgets the session to log in at the next Prepare
+	Invalidate()
+}
+
+// GaroonSession ...
+// logs in by the login form once, and reuses the session cookie
+// until Garoon rejects it
+type GaroonSession struct {
+	mu       sync.Mutex
+	loggedIn bool
+}
+
+// UsesUsernameToken ...
+func (s *GaroonSession) UsesUsernameToken() bool {
+	return false
+}
+
+// Prepare ...
+func (s *GaroonSession) Prepare(ctx context.Context, grn *Service, req *http.Request) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.loggedIn {
+		return nil
+	}
+
+	if err := GaroonLogin(ctx, grn); err != nil {
+		return err
+	}
+	s.loggedIn = true
+
+	return nil
+}
+
+// Invalidate ...
+func (s *GaroonSession) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.loggedIn = false
+}
+
+// GaroonCookieAuth ...
+// sends a session cookie obtained elsewhere (e.g. from a browser after SSO)
+type GaroonCookieAuth struct {
+	// NAME=VALUE; NAME2=VALUE2
+	Cookie string
+}
+
+// UsesUsernameToken ...
+func (a *GaroonCookieAuth) UsesUsernameToken() bool {
+	return false
+}
+
+// Prepare ...
+func (a *GaroonCookieAuth) Prepare(ctx context.Context, grn *Service, req *http.Request) error {
+	req.Header.Add("Cookie", a.Cookie)
+	return nil
+}
+
+// GaroonLogin ...
+// posts the login form and keeps the session cookie in grn.Client.Jar
+func GaroonLogin(ctx context.Context, grn *Service) error {
+	if grn.Client.Jar == nil {
+		return errors.New("Unable to login to Garoon: the http client has no cookie jar")
+	}
+
+	loginValues := url.Values{
+		"_system":    {"1"},
+		"_account":   {grn.Account},
+		"_password":  {grn.Password},
+		"use_cookie": {"1"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, grn.BaseURL+"/portal/index", strings.NewReader(loginValues.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := grn.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Unable to login to Garoon.\n... %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || 400 <= res.StatusCode {
+		return fmt.Errorf("Unable to login to Garoon.\n... %v", res.Status)
+	}
+
+	baseURL, err := url.Parse(grn.BaseURL)
+	if err != nil {
+		return err
+	}
+	if len(grn.Client.Jar.Cookies(baseURL)) == 0 {
+		return errors.New("Unable to login to Garoon.\n... no session cookie")
+	}
+
+	return nil
+}
+
 //////////////////////
 // helper functions //
 //////////////////////
 
-type garoonRPCRequest struct {
-	Username, Password string
-	Action, Parameters string
+// SOAP envelope of a request.
+// every value is escaped by encoding/xml.
+type soapEnvelope struct {
+	XMLName          xml.Name   `xml:"SOAP-ENV:Envelope"`
+	XmlnsSOAPEnv     string     `xml:"xmlns:SOAP-ENV,attr"`
+	XmlnsXSD         string     `xml:"xmlns:xsd,attr"`
+	XmlnsXSI         string     `xml:"xmlns:xsi,attr"`
+	XmlnsSOAPEnc     string     `xml:"xmlns:SOAP-ENC,attr"`
+	XmlnsBaseService string     `xml:"xmlns:base_services,attr"`
+	Header           soapHeader `xml:"SOAP-ENV:Header"`
+	Body             soapBody   `xml:"SOAP-ENV:Body"`
+}
+
+type soapHeader struct {
+	Action    soapAction    `xml:"http://schemas.xmlsoap.org/ws/2003/03/addressing Action"`
+	Security  *soapSecurity `xml:"http://schemas.xmlsoap.org/ws/2002/12/secext Security,omitempty"`
+	Timestamp soapTimestamp `xml:"http://schemas.xmlsoap.org/ws/2002/07/utility Timestamp"`
+	Locale    string        `xml:"Locale"`
+}
+
+type soapAction struct {
+	MustUnderstand string `xml:"SOAP-ENV:mustUnderstand,attr"`
+	Action         string `xml:",chardata"`
+}
+
+type soapSecurity struct {
+	MustUnderstand string            `xml:"SOAP-ENV:mustUnderstand,attr"`
+	XmlnsWSU       string            `xml:"xmlns:wsu,attr"`
+	UsernameToken  soapUsernameToken `xml:"UsernameToken"`
+}
+
+type soapUsernameToken struct {
+	ID       string `xml:"wsu:ID,attr"`
+	Username string `xml:"Username"`
+	Password string `xml:"Password"`
+}
+
+type soapTimestamp struct {
+	MustUnderstand string `xml:"SOAP-ENV:mustUnderstand,attr"`
+	ID             string `xml:"ID,attr"`
+	Created        string `xml:"Created"`
+	Expires        string `xml:"Expires"`
+}
+
+type soapBody struct {
+	Request soapRequest
+}
+
+// <{{action}}>{{parameters}}</{{action}}>
+type soapRequest struct {
+	XMLName    xml.Name
+	Parameters interface{}
+}
+
+// <parameters><event_id xmlns="">ID</event_id>...</parameters>
+type eventIDParameters struct {
+	XMLName  xml.Name         `xml:"parameters"`
+	EventIDs []eventIDElement `xml:"event_id"`
+}
+
+type eventIDElement struct {
+	Xmlns string `xml:"xmlns,attr"`
+	ID    string `xml:",chardata"`
+}
+
+func newEventIDParameters(eventIDs ...string) *eventIDParameters {
+	params := &eventIDParameters{}
+	for _, id := range eventIDs {
+		params.EventIDs = append(params.EventIDs, eventIDElement{ID: id})
+	}
+	return params
+}
+
+// builds a SOAP request created at now.
+// parameters is a struct marshaled into <parameters>, or nil.
+func (grn *Service) buildSOAPRequest(action string, parameters interface{}, now time.Time) ([]byte, error) {
+	var security *soapSecurity
+	if grn.Auth == nil || grn.Auth.UsesUsernameToken() {
+		security = &soapSecurity{
+			MustUnderstand: "1",
+			XmlnsWSU:       "http://schemas.xmlsoap.org/ws/2002/07/utility",
+			UsernameToken: soapUsernameToken{
+				ID:       "ID",
+				Username: grn.Account,
+				Password: grn.Password,
+			},
+		}
+	}
+
+	envelope := soapEnvelope{
+		XmlnsSOAPEnv:     "http://www.w3.org/2003/05/soap-envelope",
+		XmlnsXSD:         "http://www.w3.org/2001/XMLSchema",
+		XmlnsXSI:         "http://www.w3.org/2001/XMLSchema-instance",
+		XmlnsSOAPEnc:     "http://schemas.xmlsoap.org/soap/encoding/",
+		XmlnsBaseService: "http://wsdl.cybozu.co.jp/base/2008",
+		Header: soapHeader{
+			Action: soapAction{
+				MustUnderstand: "1",
+				Action:         action,
+			},
+			Security: security,
+			Timestamp: soapTimestamp{
+				MustUnderstand: "1",
+				ID:             "ID",
+				Created:        now.UTC().Format(time.RFC3339),
+				Expires:        now.Add(grn.TimestampValidity).UTC().Format(time.RFC3339),
+			},
+			Locale: grn.Locale,
+		},
+		Body: soapBody{
+			Request: soapRequest{
+				XMLName:    xml.Name{Local: action},
+				Parameters: parameters,
+			},
+		},
+	}
+
+	marshaled, err := xml.MarshalIndent(envelope, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), marshaled...), nil
 }
 
 func decodeXML(result interface{}, r io.Reader) error {
@@ -194,60 +797,102 @@ func decodeXML(result interface{}, r io.Reader) error {
 	return nil
 }
 
-func (grn *Service) callGaroonProc(path string, action string, parameters string, result interface{}) error {
-	req := garoonRPCRequest{
-		Action:     action,
-		Parameters: parameters,
-		Username:   grn.Account,
-		Password:   grn.Password,
-	}
-
-	reqbody := bytes.NewBufferString("")
-
-	templ := template.Must(template.New("payload").Parse(`<?xml version="1.0" encoding="UTF-8"?>
-<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://www.w3.org/2003/05/soap-envelope"
-    xmlns:xsd="http://www.w3.org/2001/XMLSchema"
-    xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"
-    xmlns:SOAP-ENC="http://schemas.xmlsoap.org/soap/encoding/"
-    xmlns:base_services="http://wsdl.cybozu.co.jp/base/2008">
-    <SOAP-ENV:Header>
-        <Action SOAP-ENV:mustUnderstand="1"
-            xmlns="http://schemas.xmlsoap.org/ws/2003/03/addressing">
-            {{.Action}}
-        </Action>
-        <Security xmlns:wsu="http://schemas.xmlsoap.org/ws/2002/07/utility"
-            SOAP-ENV:mustUnderstand="1"
-            xmlns="http://schemas.xmlsoap.org/ws/2002/12/secext">
-            <UsernameToken wsu:ID="ID">
-                <Username>{{.Username}}</Username>
-                <Password>{{.Password}}</Password>
-            </UsernameToken>
-        </Security>
-        <Timestamp SOAP-ENV:mustUnderstand="1" ID="ID"
-            xmlns="http://schemas.xmlsoap.org/ws/2002/07/utility">
-            <Created>2037-08-12T14:45:00Z</Created>
-            <Expires>2037-08-12T14:45:00Z</Expires>
-        </Timestamp>
-        <Locale>jp</Locale>
-    </SOAP-ENV:Header>
-    <SOAP-ENV:Body>
-        <{{.Action}}>
-        {{.Parameters}}
-    </{{.Action}}>
-</SOAP-ENV:Body>
-</SOAP-ENV:Envelope>`))
-	err := templ.Execute(reqbody, req)
+// GaroonFault ...
+// an error response of Garoon
+type GaroonFault struct {
+	StatusCode int    `xml:"-"`
+	Status     string `xml:"-"`
+	Code       string `xml:"Body>Fault>Detail>code"`
+	Diagnosis  string `xml:"Body>Fault>Detail>diagnosis"`
+}
+
+// fault codes of unauthenticated requests
+var garoonAuthFaultCodes = map[string]bool{
+	"FW00007":        true, // authentication failed
+	"GRN_CMMN_00105": true, // login required
+}
+
+// fault codes of events deleted or not open to the user
+var garoonNotFoundFaultCodes = map[string]bool{
+	"GRN_SCHD_13001": true, // no such event
+	"GRN_SCHD_13002": true, // no permission to view
+}
+
+func (f *GaroonFault) Error() string {
+	msg := f.Status
+	if f.Code != "" {
+		msg += ": " + f.Code
+	}
+	if f.Diagnosis != "" {
+		msg += " " + f.Diagnosis
+	}
+	return msg
+}
+
+// IsAuth ...
+// whether Garoon rejected the credentials or the session
+func (f *GaroonFault) IsAuth() bool {
+	return f.StatusCode == http.StatusUnauthorized || f.StatusCode == http.StatusForbidden || garoonAuthFaultCodes[f.Code]
+}
+
+// IsNotFound ...
+// whether an event is deleted or not open to the user
+func (f *GaroonFault) IsNotFound() bool {
+	return garoonNotFoundFaultCodes[f.Code]
+}
+
+// calls a SOAP action.
+// a request rejected for an expired session is retried once after logging in again.
+func (grn *Service) callGaroonProc(ctx context.Context, path string, action string, parameters interface{}, result interface{}) error {
+	err := grn.doGaroonProc(ctx, path, action, parameters, result)
+
+	var fault *GaroonFault
+	if auth, ok := grn.Auth.(GaroonRenewableAuth); ok && errors.As(err, &fault) && fault.IsAuth() {
+		auth.Invalidate()
+		err = grn.doGaroonProc(ctx, path, action, parameters, result)
+	}
+
+	return err
+}
+
+func (grn *Service) doGaroonProc(ctx context.Context, path string, action string, parameters interface{}, result interface{}) error {
+	payload, err := grn.buildSOAPRequest(action, parameters, time.Now())
 	if err != nil {
 		return err
 	}
+	reqbody := bytes.NewReader(payload)
 
 	//fmt.Printf("%v\n", reqbody)
-	response, err := http.Post(grn.BaseURL+path, "text/xml; charset=utf-8", reqbody)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, grn.BaseURL+path, reqbody)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "text/xml; charset=utf-8")
+
+	if grn.Auth != nil {
+		if err := grn.Auth.Prepare(ctx, grn, request); err != nil {
+			return err
+		}
+	}
+
+	response, err := grn.Client.Do(request)
 	if err != nil {
 		return err
 	}
 	defer response.Body.Close()
 
+	if response.StatusCode < 200 || 300 <= response.StatusCode {
+		fault := &GaroonFault{StatusCode: response.StatusCode, Status: response.Status}
+		decodeXML(fault, response.Body)
+		return fmt.Errorf("Garoon %s: %w", action, fault)
+	}
+
+	// the login page instead of a SOAP response
+	if strings.HasPrefix(response.Header.Get("Content-Type"), "text/html") {
+		fault := &GaroonFault{StatusCode: http.StatusUnauthorized, Status: response.Status, Diagnosis: "not logged in"}
+		return fmt.Errorf("Garoon %s: %w", action, fault)
+	}
+
 	err = decodeXML(&result, response.Body)
 	if err != nil {
 		return err
@@ -261,11 +906,6 @@ func (grn *Service) callGaroonProc(path string, action string, parameters string
 ////////////////
 
 /*
-type GaroonSession struct {
-	Client *http.Client
-	UserID string // uid
-}
-
 type GaroonEvent struct {
 	ID    string
 	Type  GaroonEventType
@@ -298,32 +938,4 @@ const (
 const (
 	GaroonPathMonthlyView string = "/schedule/personal_month?bdate=%(year)04d-%(month)02d-01&uid=%(uid)d&gid=&search_text="
 )
-
-func GaroonLogin(config *GaroonConfig) (*GaroonSession, error) {
-	session := &GaroonSession{}
-
-	jar, err := cookiejar.New(nil)
-	if err != nil {
-		return nil, fmt.Errorf("Unable to create cookie jar.\n... %v", err)
-	}
-
-	client := &http.Client{Jar: jar}
-	session.Client = client
-
-	// login
-
-	loginValues := url.Values{
-		"_system":    {"1"},
-		"_account":   {config.Account},
-		"_password":  {config.Password},
-		"use_cookie": {"1"}}
-	res, err := client.PostForm(config.BaseURL+"/portal/index", loginValues)
-	if err != nil {
-		return nil, fmt.Errorf("Unable to login to Garoon.\n... %v", err)
-	}
-	res.Body.Close()
-	fmt.Printf("Status: %v, StatusCode: %v, Trailer: %v\n", res.Status, res.StatusCode, res.Trailer)
-
-	return session, nil
-}
 */
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// decodes a recorded SOAP response in testdata
+func loadGaroonFixture(t *testing.T, name string, result interface{}) {
+	t.Helper()
+
+	file, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if err := decodeXML(result, file); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+}
+
+func TestScheduleGetEventsTemporary(t *testing.T) {
+	var result ScheduleGetEventsResult
+	loadGaroonFixture(t, "ScheduleGetEventsResponse_temporary.xml", &result)
+
+	if len(result.Events) != 2 {
+		t.Fatalf("len(Events) = %d, want 2", len(result.Events))
+	}
+
+	temporary := result.Events[0]
+	if temporary.ID != "101" || temporary.EventType != GaroonEventTypeTemporary {
+		t.Errorf("event = %s %s, want 101 temporary", temporary.ID, temporary.EventType)
+	}
+	if len(temporary.Datetime) != 3 {
+		t.Fatalf("len(Datetime) = %d, want 3 candidates", len(temporary.Datetime))
+	}
+	if got := temporary.Datetime[1].Start; got != "2020-06-02T05:00:00Z" {
+		t.Errorf("Datetime[1].Start = %s", got)
+	}
+	if len(temporary.Members) != 1 || temporary.Members[0].ID != "7" {
+		t.Errorf("Members = %+v", temporary.Members)
+	}
+
+	banner := result.Events[1]
+	if banner.EventType != GaroonEventTypeBanner || len(banner.Date) != 1 || banner.Date[0].End != "2020-06-03" {
+		t.Errorf("banner = %+v", banner)
+	}
+}
+
+func TestScheduleGetEventsByIDConfirmed(t *testing.T) {
+	var result ScheduleGetEventsByIDResult
+	loadGaroonFixture(t, "ScheduleGetEventsByIdResponse_confirmed.xml", &result)
+
+	if len(result.Events) != 1 {
+		t.Fatalf("len(Events) = %d, want 1", len(result.Events))
+	}
+	if e := result.Events[0]; e.ID != "101" || e.EventType != GaroonEventTypeNormal || len(e.Datetime) != 1 {
+		t.Errorf("event = %+v", e)
+	}
+}
+
+func TestScheduleGetEventsRepeatExclusive(t *testing.T) {
+	var result ScheduleGetEventsResult
+	loadGaroonFixture(t, "ScheduleGetEventsResponse_repeat.xml", &result)
+
+	repeat := result.Events[0]
+	if repeat.Repeat == nil || len(repeat.Repeat.Exclusive) != 1 || repeat.Repeat.Exclusive[0].Start != "2020-06-15T00:00:00+09:00" {
+		t.Fatalf("Repeat = %+v", repeat.Repeat)
+	}
+
+	srcEvent, err := convertGrnEventIntoSourceEvent(repeat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(srcEvent.Exclusions) != 1 {
+		t.Fatalf("Exclusions = %v", srcEvent.Exclusions)
+	}
+
+	cal := newICSCalendar(time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC))
+	if err := cal.Add(srcEvent, &SyncConfig{}, "garoon.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	var ics bytes.Buffer
+	if _, err := cal.WriteTo(&ics); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(ics.String(), "EXDATE;TZID=Asia/Tokyo:20200615T100000\r\n") {
+		t.Errorf("no EXDATE in\n%s", ics.String())
+	}
+}
+
+func TestScheduleGetEventsByIDFault(t *testing.T) {
+	fault, err := os.ReadFile(filepath.Join("testdata", "ScheduleGetEventsByIdResponse_fault.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		code     string
+		notFound bool
+	}{
+		{"GRN_SCHD_13001", true},
+		{"GRN_SCHD_13002", true},
+		{"GRN_CMMN_00001", false},
+	} {
+		body := strings.Replace(string(fault), "GRN_SCHD_13001", c.code, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, body)
+		}))
+		grn := NewGaroon("user", "password", server.URL, server.Client())
+
+		result, err := grn.ScheduleGetEventsByID(context.Background(), "101")
+		server.Close()
+
+		if c.notFound {
+			if err != nil || len(result.Events) != 0 {
+				t.Errorf("%s: %v, %+v, want no events", c.code, err, result)
+			}
+			continue
+		}
+		var f *GaroonFault
+		if !errors.As(err, &f) || f.Code != c.code || f.StatusCode != http.StatusInternalServerError {
+			t.Errorf("%s: err = %v, want the fault", c.code, err)
+		}
+	}
+}
+
+// whether XML can carry s as it is
+func isXMLText(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if !(r == 0x09 || r == 0x0A || r == 0x0D ||
+			r >= 0x20 && r <= 0xD7FF ||
+			r >= 0xE000 && r <= 0xFFFD ||
+			r >= 0x10000 && r <= 0x10FFFF) {
+			return false
+		}
+	}
+	return true
+}
+
+func FuzzBuildSOAPRequest(f *testing.F) {
+	f.Add("user", "password", "123")
+	f.Add("山田", "p<a&s>s\"w'o]]>rd", "1</event_id><event_id>2")
+	f.Add("", "", "")
+	f.Add(" a\tb ", "\r\n", "<![CDATA[x]]>")
+
+	f.Fuzz(func(t *testing.T, account, password, eventID string) {
+		if !isXMLText(account) || !isXMLText(password) || !isXMLText(eventID) {
+			t.Skip()
+		}
+
+		grn := NewGaroon(account, password, "https://garoon.example.com", nil)
+		payload, err := grn.buildSOAPRequest("ScheduleGetEventsById", newEventIDParameters(eventID), time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var parsed struct {
+			Action   string   `xml:"Header>Action"`
+			Username string   `xml:"Header>Security>UsernameToken>Username"`
+			Password string   `xml:"Header>Security>UsernameToken>Password"`
+			Created  string   `xml:"Header>Timestamp>Created"`
+			EventIDs []string `xml:"Body>ScheduleGetEventsById>parameters>event_id"`
+		}
+		if err := xml.NewDecoder(bytes.NewReader(payload)).Decode(&parsed); err != nil {
+			t.Fatalf("not XML: %v\n%s", err, payload)
+		}
+
+		if parsed.Action != "ScheduleGetEventsById" {
+			t.Errorf("Action = %q", parsed.Action)
+		}
+		if parsed.Username != account || parsed.Password != password {
+			t.Errorf("credentials = %q %q, want %q %q", parsed.Username, parsed.Password, account, password)
+		}
+		if parsed.Created != "2020-06-01T00:00:00Z" {
+			t.Errorf("Created = %q", parsed.Created)
+		}
+		if len(parsed.EventIDs) != 1 || parsed.EventIDs[0] != eventID {
+			t.Errorf("event_id = %q, want %q", parsed.EventIDs, eventID)
+		}
+	})
+}
+
+// a Garoon whose sessions expire after a SOAP request
+func TestBuildSOAPRequestHeader(t *testing.T) {
+	jst := time.FixedZone("JST", 9*60*60)
+	now := time.Date(2020, 6, 1, 9, 0, 0, 0, jst)
+
+	for _, c := range []struct {
+		config  GaroonConfig
+		locale  string
+		expires string
+	}{
+		{GaroonConfig{}, "jp", "2020-06-01T00:10:00Z"},
+		{GaroonConfig{Locale: "ja", TimestampValidity: "1h"}, "jp", "2020-06-01T01:00:00Z"},
+		{GaroonConfig{Locale: "en", TimestampValidity: "30s"}, "en", "2020-06-01T00:00:30Z"},
+		{GaroonConfig{Locale: "zh"}, "zh", "2020-06-01T00:10:00Z"},
+	} {
+		c.config.BaseURL = "https://garoon.example.com"
+		grn, err := newGaroonService(&Config{Garoon: c.config})
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload, err := grn.buildSOAPRequest("UtilGetLoginUserId", nil, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var parsed struct {
+			Created string `xml:"Header>Timestamp>Created"`
+			Expires string `xml:"Header>Timestamp>Expires"`
+			Locale  string `xml:"Header>Locale"`
+		}
+		if err := xml.Unmarshal(payload, &parsed); err != nil {
+			t.Fatal(err)
+		}
+		if parsed.Created != "2020-06-01T00:00:00Z" || parsed.Expires != c.expires || parsed.Locale != c.locale {
+			t.Errorf("%+v: Created = %s, Expires = %s, Locale = %q, want %s %q", c.config, parsed.Created, parsed.Expires, parsed.Locale, c.expires, c.locale)
+		}
+	}
+}
+
+// a Garoon answering UtilGetLoginUserId with user 7, passing every request to inspect
+func newInspectingGaroonServer(t *testing.T, inspect func(r *http.Request, body []byte)) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		inspect(r, body)
+
+		w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope"><soap:Body><util:GetRequestTokenResponse xmlns:util="http://wsdl.cybozu.co.jp/util_api/2008"><returns><user_id>7</user_id></returns></util:GetRequestTokenResponse></soap:Body></soap:Envelope>`)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGaroonRequestTimestamp(t *testing.T) {
+	var created, expires time.Time
+	server := newInspectingGaroonServer(t, func(r *http.Request, body []byte) {
+		var parsed struct {
+			Created string `xml:"Header>Timestamp>Created"`
+			Expires string `xml:"Header>Timestamp>Expires"`
+		}
+		xml.Unmarshal(body, &parsed)
+		created, _ = time.Parse(time.RFC3339, parsed.Created)
+		expires, _ = time.Parse(time.RFC3339, parsed.Expires)
+	})
+	grn := NewGaroon("user", "password", server.URL, server.Client())
+
+	before := time.Now().Add(-time.Second)
+	if _, err := grn.UtilGetLoginUserID(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if created.Before(before) || created.After(time.Now()) || expires.Sub(created) != defaultGaroonTimestampValidity {
+		t.Errorf("Created = %v, Expires = %v, want now and %v later", created, expires, defaultGaroonTimestampValidity)
+	}
+}
+
+func TestGaroonHTTPClientHeaders(t *testing.T) {
+	var got http.Header
+	server := newInspectingGaroonServer(t, func(r *http.Request, body []byte) {
+		got = r.Header.Clone()
+	})
+
+	for _, c := range []struct {
+		config GaroonConfig
+		want   map[string]string // "" for absent
+	}{
+		{
+			config: GaroonConfig{Account: "user", Password: "password"},
+			want:   map[string]string{"Authorization": "", "X-Cybozu-Authorization": ""},
+		},
+		{
+			config: GaroonConfig{
+				Account: "user", Password: "password",
+				BasicAuthUser: "proxy", BasicAuthPassword: "secret",
+				CybozuAuthorization: true,
+				Headers:             map[string]string{"X-Tenant": "example", "User-Agent": "grn2gcal"},
+			},
+			want: map[string]string{
+				"Authorization":          "Basic cHJveHk6c2VjcmV0", // proxy:secret
+				"X-Cybozu-Authorization": "dXNlcjpwYXNzd29yZA==",   // user:password
+				"X-Tenant":               "example",
+				"User-Agent":             "grn2gcal",
+			},
+		},
+	} {
+		client, err := NewGaroonHTTPClient(&c.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		grn := NewGaroon(c.config.Account, c.config.Password, server.URL, client)
+
+		got = nil
+		if _, err := grn.UtilGetLoginUserID(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range c.want {
+			if got.Get(k) != v {
+				t.Errorf("%s = %q, want %q", k, got.Get(k), v)
+			}
+		}
+	}
+}
+
+func TestGaroonHTTPClientPKCS12(t *testing.T) {
+	var clientCN string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			clientCN = r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+		w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+		fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope"><soap:Body><util:GetRequestTokenResponse xmlns:util="http://wsdl.cybozu.co.jp/util_api/2008"><returns><user_id>7</user_id></returns></util:GetRequestTokenResponse></soap:Body></soap:Envelope>`)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	caCertFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caCertFile, caPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// testdata/client.p12: a self-signed P-256 certificate "grn2gcal client", made by
+	//   openssl req -x509 -newkey ec -pkeyopt ec_paramgen_curve:P-256 -nodes -keyout key.pem -out cert.pem -days 36500 -subj "/CN=grn2gcal client"
+	//   openssl pkcs12 -export -inkey key.pem -in cert.pem -out client.p12 -passout pass:secret -certpbe PBE-SHA1-3DES -keypbe PBE-SHA1-3DES -macalg sha1
+	config := &GaroonConfig{
+		CACertFile:         caCertFile,
+		ClientCertFile:     filepath.Join("testdata", "client.p12"),
+		ClientCertPassword: "secret",
+	}
+	client, err := NewGaroonHTTPClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grn := NewGaroon("user", "password", server.URL, client)
+	result, err := grn.UtilGetLoginUserID(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.UserID != "7" || clientCN != "grn2gcal client" {
+		t.Errorf("user = %q, client certificate = %q", result.UserID, clientCN)
+	}
+
+	// without a client certificate, the handshake fails
+	client, err = NewGaroonHTTPClient(&GaroonConfig{CACertFile: caCertFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewGaroon("user", "password", server.URL, client).UtilGetLoginUserID(context.Background()); err == nil {
+		t.Error("no error without a client certificate")
+	}
+
+	// a wrong password
+	config.ClientCertPassword = "wrong"
+	if _, err := NewGaroonHTTPClient(config); err == nil {
+		t.Error("no error with a wrong password")
+	}
+}
+
+func newExpiringGaroonServer(t *testing.T, logins *int) *httptest.Server {
+	t.Helper()
+
+	session := ""
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/portal/index":
+			*logins++
+			session = fmt.Sprintf("s%d", *logins)
+			http.SetCookie(w, &http.Cookie{Name: "CB_SID", Value: session, Path: "/"})
+
+		case strings.TrimSuffix(UtilServicePath, "?"):
+			c, err := r.Cookie("CB_SID")
+			if err != nil || session == "" || c.Value != session {
+				w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope"><soap:Body><soap:Fault><soap:Code><soap:Value>soap:Sender</soap:Value></soap:Code><soap:Reason><soap:Text xml:lang="en">authentication failed</soap:Text></soap:Reason><soap:Detail><code>FW00007</code><diagnosis>authentication failed</diagnosis></soap:Detail></soap:Fault></soap:Body></soap:Envelope>`)
+				return
+			}
+			session = ""
+
+			w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+			fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?><soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope"><soap:Body><util:GetRequestTokenResponse xmlns:util="http://wsdl.cybozu.co.jp/util_api/2008"><returns><user_id>7</user_id></returns></util:GetRequestTokenResponse></soap:Body></soap:Envelope>`)
+
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestGaroonSessionRenewal(t *testing.T) {
+	logins := 0
+	server := newExpiringGaroonServer(t, &logins)
+	defer server.Close()
+
+	client, err := NewGaroonHTTPClient(&GaroonConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	grn := NewGaroon("user", "password", server.URL, client)
+	grn.Auth = &GaroonSession{}
+
+	for i := 1; i <= 2; i++ {
+		result, err := grn.UtilGetLoginUserID(context.Background())
+		if err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+		if result.UserID != "7" {
+			t.Errorf("call %d: user_id = %q", i, result.UserID)
+		}
+		if logins != i {
+			t.Errorf("call %d: logins = %d, want %d", i, logins, i)
+		}
+	}
+
+	// rejected even after logging in again
+	grn.Auth = &GaroonCookieAuth{Cookie: "CB_SID=stale"}
+	_, err = grn.UtilGetLoginUserID(context.Background())
+	var fault *GaroonFault
+	if !errors.As(err, &fault) || !fault.IsAuth() || fault.Code != "FW00007" {
+		t.Errorf("err = %v, want an auth fault", err)
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+/*
+ * memo
+ *  a schedule CSV exported from Garoon:
+ *    開始日付,開始時刻,終了日付,終了時刻,予定,予定詳細,メモ
+ *    2006/01/02,15:04:05,2006/01/02,16:04:05,会議,定例会,...
+ *  no IDs in CSV, so IDs are hashes of start and title.
+ *  hence a rescheduled or renamed event is another event: it is deleted and
+ *  added again in the target, losing what was edited there (reminders and so on).
+ */
+
+// garoonSource ...
+// Garoon events the sync engine reads.
+// implemented by Service (the API) and GaroonCSV.
+type garoonSource interface {
+	UtilGetLoginUserID(ctx context.Context) (UtilGetLoginUserIDResult, error)
+	ScheduleGetEvents(ctx context.Context, start, end time.Time) (ScheduleGetEventsResult, error)
+	ScheduleGetEventsByID(ctx context.Context, eventID string) (ScheduleGetEventsByIDResult, error)
+}
+
+// the user of all events in a CSV
+const garoonCSVUserID = "csv"
+
+// CSV columns
+const (
+	garoonCSVStartDate   string = "開始日付"
+	garoonCSVStartTime   string = "開始時刻"
+	garoonCSVEndDate     string = "終了日付"
+	garoonCSVEndTime     string = "終了時刻"
+	garoonCSVPlan        string = "予定"
+	garoonCSVDetail      string = "予定詳細"
+	garoonCSVDescription string = "メモ"
+)
+
+// GaroonCSV ...
+// Garoon events read from a schedule CSV export
+type GaroonCSV struct {
+	Events []*GaroonEvent
+
+	// start and end of each event
+	spans map[string][2]time.Time
+}
+
+// NewGaroonCSV ...
+// reads a CSV file, or the newest *.csv in a directory.
+// times are in timeZone (default: local).
+func NewGaroonCSV(path, timeZone string) (*GaroonCSV, error) {
+	loc := time.Local
+	if timeZone != "" {
+		var err error
+		loc, err = time.LoadLocation(timeZone)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	path, err := newestCSVFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseGaroonCSV(data, loc)
+}
+
+// a file itself, or the newest *.csv in a directory
+func newestCSVFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return path, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(path, "*.csv"))
+	if err != nil {
+		return "", err
+	}
+
+	newest := ""
+	var newestTime time.Time
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil || fi.IsDir() {
+			continue
+		}
+		if newest == "" || fi.ModTime().After(newestTime) {
+			newest, newestTime = f, fi.ModTime()
+		}
+	}
+	if newest == "" {
+		return "", fmt.Errorf("no CSV files in %s", path)
+	}
+	return newest, nil
+}
+
+// parses CSV in Shift_JIS or UTF-8
+func parseGaroonCSV(data []byte, loc *time.Location) (*GaroonCSV, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		decoded, err := japanese.ShiftJIS.NewDecoder().Bytes(data)
+		if err != nil {
+			return nil, err
+		}
+		data = decoded
+	}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	if _, found := columns[garoonCSVStartDate]; !found {
+		return nil, fmt.Errorf("no %s column in CSV", garoonCSVStartDate)
+	}
+
+	grnCSV := &GaroonCSV{spans: make(map[string][2]time.Time)}
+	ids := make(map[string]int)
+
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		column := func(name string) string {
+			if i, found := columns[name]; found && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		grnEvent, span, err := convertCSVRecordIntoGrnEvent(column, loc)
+		if err != nil {
+			return nil, fmt.Errorf("CSV line %d: %v", line, err)
+		}
+
+		// the same start and title
+		ids[grnEvent.ID]++
+		if n := ids[grnEvent.ID]; n > 1 {
+			grnEvent.ID = fmt.Sprintf("%s-%d", grnEvent.ID, n)
+		}
+
+		grnCSV.Events = append(grnCSV.Events, grnEvent)
+		grnCSV.spans[grnEvent.ID] = span
+	}
+
+	return grnCSV, nil
+}
+
+func convertCSVRecordIntoGrnEvent(column func(string) string, loc *time.Location) (*GaroonEvent, [2]time.Time, error) {
+	var span [2]time.Time
+
+	startDate, err := parseCSVDate(column(garoonCSVStartDate), loc)
+	if err != nil {
+		return nil, span, err
+	}
+	endDate := startDate
+	if column(garoonCSVEndDate) != "" {
+		endDate, err = parseCSVDate(column(garoonCSVEndDate), loc)
+		if err != nil {
+			return nil, span, err
+		}
+	}
+
+	grnEvent := &GaroonEvent{
+		EventType:   GaroonEventTypeNormal,
+		PublicType:  "public",
+		Plan:        column(garoonCSVPlan),
+		Detail:      column(garoonCSVDetail),
+		Description: column(garoonCSVDescription),
+		TimeZone:    timeZoneName(loc),
+	}
+	grnEvent.Members = append(grnEvent.Members, &GaroonMember{ID: garoonCSVUserID})
+
+	hash := fnv.New64a()
+	for _, v := range []string{column(garoonCSVStartDate), column(garoonCSVStartTime), grnEvent.Plan, grnEvent.Detail} {
+		hash.Write([]byte(v))
+		hash.Write([]byte{0})
+	}
+	grnEvent.ID = fmt.Sprintf("csv-%x", hash.Sum64())
+
+	if column(garoonCSVStartTime) == "" {
+		// all-day
+		grnEvent.Date = append(grnEvent.Date, &GaroonSpan{
+			Start: startDate.Format("2006-01-02"),
+			End:   endDate.Format("2006-01-02"),
+		})
+
+		span = [2]time.Time{startDate, endDate.AddDate(0, 0, 1)}
+		return grnEvent, span, nil
+	}
+
+	start, err := parseCSVTime(startDate, column(garoonCSVStartTime))
+	if err != nil {
+		return nil, span, err
+	}
+	end := start
+	if column(garoonCSVEndTime) != "" {
+		end, err = parseCSVTime(endDate, column(garoonCSVEndTime))
+		if err != nil {
+			return nil, span, err
+		}
+	} else {
+		grnEvent.StartOnly = true
+	}
+
+	datetime := &GaroonSpan{
+		Start: start.UTC().Format(time.RFC3339),
+	}
+	if !grnEvent.StartOnly {
+		datetime.End = end.UTC().Format(time.RFC3339)
+	}
+	grnEvent.Datetime = append(grnEvent.Datetime, datetime)
+
+	span = [2]time.Time{start, end}
+	return grnEvent, span, nil
+}
+
+// the IANA name of a location.
+// time.Local is named "Local", which is not a time zone (TZID) of Gcal or ICS;
+// it is named after TZ or /etc/localtime, or UTC if unknown.
+func timeZoneName(loc *time.Location) string {
+	if loc != time.Local {
+		return loc.String()
+	}
+
+	candidates := []string{strings.TrimPrefix(os.Getenv("TZ"), ":")}
+	if link, err := filepath.EvalSymlinks("/etc/localtime"); err == nil {
+		if i := strings.LastIndex(link, "zoneinfo/"); i >= 0 {
+			candidates = append(candidates, link[i+len("zoneinfo/"):])
+		}
+	}
+	for _, name := range candidates {
+		if name == "" || name == "Local" {
+			continue
+		}
+		if _, err := time.LoadLocation(name); err == nil {
+			return name
+		}
+	}
+
+	// datetimes are in UTC, so only the zone to show them in is lost
+	return "UTC"
+}
+
+// such as "2006/01/02" or "2006-01-02"
+func parseCSVDate(value string, loc *time.Location) (time.Time, error) {
+	for _, layout := range []string{"2006/1/2", "2006-1-2"} {
+		if d, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return d, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
+
+// such as "15:04:05" or "15:04" on a date
+func parseCSVTime(date time.Time, value string) (time.Time, error) {
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return time.Date(date.Year(), date.Month(), date.Day(), t.Hour(), t.Minute(), t.Second(), 0, date.Location()), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
+
+// UtilGetLoginUserID ...
+// returns the user of all events
+func (grnCSV *GaroonCSV) UtilGetLoginUserID(ctx context.Context) (UtilGetLoginUserIDResult, error) {
+	return UtilGetLoginUserIDResult{UserID: garoonCSVUserID}, nil
+}
+
+// ScheduleGetEvents ...
+// returns events between start and end
+func (grnCSV *GaroonCSV) ScheduleGetEvents(ctx context.Context, start, end time.Time) (ScheduleGetEventsResult, error) {
+	result := ScheduleGetEventsResult{}
+
+	for _, grnEvent := range grnCSV.Events {
+		span := grnCSV.spans[grnEvent.ID]
+		if span[1].Before(start) || !span[0].Before(end) {
+			continue
+		}
+		result.Events = append(result.Events, grnEvent)
+	}
+
+	return result, nil
+}
+
+// ScheduleGetEventsByID ...
+// returns an event, or no events if not found
+func (grnCSV *GaroonCSV) ScheduleGetEventsByID(ctx context.Context, eventID string) (ScheduleGetEventsByIDResult, error) {
+	result := ScheduleGetEventsByIDResult{}
+
+	if eventID == "" {
+		return result, errors.New("no event id")
+	}
+	for _, grnEvent := range grnCSV.Events {
+		if grnEvent.ID == eventID {
+			result.Events = append(result.Events, grnEvent)
+			break
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+func TestParseGaroonCSVTimeZone(t *testing.T) {
+	data := []byte("開始日付,開始時刻,終了日付,終了時刻,予定,予定詳細,メモ\n" +
+		"2020/06/08,10:00:00,2020/06/08,11:00:00,会議,定例,\n")
+
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, loc := range []*time.Location{tokyo, time.Local} {
+		grnCSV, err := parseGaroonCSV(data, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tz := grnCSV.Events[0].TimeZone
+		if tz == "" || tz == "Local" {
+			t.Errorf("%v: TimeZone = %q, want an IANA name", loc, tz)
+			continue
+		}
+		if _, err := time.LoadLocation(tz); err != nil {
+			t.Errorf("%v: TimeZone = %q: %v", loc, tz, err)
+		}
+	}
+
+	grnCSV, err := parseGaroonCSV(data, tokyo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := grnCSV.Events[0]; e.TimeZone != "Asia/Tokyo" || e.Datetime[0].Start != "2020-06-08T01:00:00Z" {
+		t.Errorf("event = %s %s", e.TimeZone, e.Datetime[0].Start)
+	}
+}
+
+func TestParseGaroonCSV(t *testing.T) {
+	const header = "開始日付,開始時刻,終了日付,終了時刻,予定,予定詳細,メモ\n"
+	sjis := func(s string) []byte {
+		t.Helper()
+
+		encoded, err := japanese.ShiftJIS.NewEncoder().String(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []byte(encoded)
+	}
+
+	type event struct {
+		detail   string
+		date     *GaroonSpan
+		datetime *GaroonSpan
+	}
+	for _, c := range []struct {
+		name string
+		data []byte
+		want []event
+	}{
+		{
+			name: "UTF-8 with BOM",
+			data: []byte("\xef\xbb\xbf" + header + "2020/06/08,10:00:00,2020/06/08,11:00:00,会議,定例,\n"),
+			want: []event{{detail: "定例", datetime: &GaroonSpan{Start: "2020-06-08T10:00:00Z", End: "2020-06-08T11:00:00Z"}}},
+		},
+		{
+			name: "Shift_JIS",
+			data: sjis(header + "2020/06/08,10:00:00,2020/06/08,11:00:00,会議,定例,議事録\n"),
+			want: []event{{detail: "定例", datetime: &GaroonSpan{Start: "2020-06-08T10:00:00Z", End: "2020-06-08T11:00:00Z"}}},
+		},
+		{
+			name: "all-day",
+			data: []byte(header + "2020/06/08,,2020/06/09,,休暇,夏季休暇,\n2020-6-10,,,,,出張,\n"),
+			want: []event{
+				{detail: "夏季休暇", date: &GaroonSpan{Start: "2020-06-08", End: "2020-06-09"}},
+				{detail: "出張", date: &GaroonSpan{Start: "2020-06-10", End: "2020-06-10"}},
+			},
+		},
+		{
+			name: "start-only",
+			data: []byte(header + "2020/06/08,9:30,,,,朝会,\n"),
+			want: []event{{detail: "朝会", datetime: &GaroonSpan{Start: "2020-06-08T09:30:00Z"}}},
+		},
+	} {
+		grnCSV, err := parseGaroonCSV(c.data, time.UTC)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if len(grnCSV.Events) != len(c.want) {
+			t.Errorf("%s: %d events, want %d", c.name, len(grnCSV.Events), len(c.want))
+			continue
+		}
+		for i, want := range c.want {
+			e := grnCSV.Events[i]
+			if e.Detail != want.detail {
+				t.Errorf("%s: detail = %q, want %q", c.name, e.Detail, want.detail)
+			}
+			if want.date != nil && (len(e.Date) != 1 || *e.Date[0] != *want.date || len(e.Datetime) != 0) {
+				t.Errorf("%s: %s: date = %v, datetime = %v, want date %v", c.name, want.detail, e.Date, e.Datetime, *want.date)
+			}
+			if want.datetime != nil && (len(e.Datetime) != 1 || *e.Datetime[0] != *want.datetime || len(e.Date) != 0) {
+				t.Errorf("%s: %s: date = %v, datetime = %v, want datetime %v", c.name, want.detail, e.Date, e.Datetime, *want.datetime)
+			}
+			if startOnly := want.datetime != nil && want.datetime.End == ""; e.StartOnly != startOnly {
+				t.Errorf("%s: %s: StartOnly = %v", c.name, want.detail, e.StartOnly)
+			}
+		}
+	}
+}
+
+func TestParseGaroonCSVDuplicateIDs(t *testing.T) {
+	// the same start and title; the second and third differ only in end and memo
+	data := []byte("開始日付,開始時刻,終了日付,終了時刻,予定,予定詳細,メモ\n" +
+		"2020/06/08,10:00,2020/06/08,11:00,会議,定例,\n" +
+		"2020/06/08,10:00,2020/06/08,12:00,会議,定例,延長\n" +
+		"2020/06/08,10:00,2020/06/08,10:30,会議,定例,\n" +
+		"2020/06/08,10:00,2020/06/08,11:00,会議,臨時,\n")
+
+	grnCSV, err := parseGaroonCSV(data, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grnCSV.Events) != 4 {
+		t.Fatalf("%d events", len(grnCSV.Events))
+	}
+
+	first := grnCSV.Events[0].ID
+	if !strings.HasPrefix(first, "csv-") {
+		t.Errorf("ID = %q", first)
+	}
+	for i, want := range []string{first, first + "-2", first + "-3"} {
+		if id := grnCSV.Events[i].ID; id != want {
+			t.Errorf("ID[%d] = %q, want %q", i, id, want)
+		}
+	}
+	if id := grnCSV.Events[3].ID; strings.HasPrefix(id, first) {
+		t.Errorf("ID of another title = %q", id)
+	}
+
+	// each is found by its own ID
+	for _, grnEvent := range grnCSV.Events {
+		result, err := grnCSV.ScheduleGetEventsByID(context.Background(), grnEvent.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Events) != 1 || result.Events[0] != grnEvent {
+			t.Errorf("ScheduleGetEventsByID(%s) = %d events", grnEvent.ID, len(result.Events))
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"hash/fnv"
 	"io"
@@ -16,17 +18,41 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
-	//"errors"
 
 	calendar "google.golang.org/api/calendar/v3"
 
 	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
 )
 
+// Gcal authorization modes
+const (
+	// browser if available, otherwise device or paste
+	AuthModeAuto string = "auto"
+	// open a browser and wait for a redirect to a local server
+	AuthModeBrowser string = "browser"
+	// OAuth device authorization flow
+	AuthModeDevice string = "device"
+	// paste a redirected URL or a code
+	AuthModePaste string = "paste"
+)
+
+// a redirect URL for AuthModePaste
+const pasteRedirectURL = "http://127.0.0.1"
+
 // LoginGcal ...
-// opens browser and authenticate as a gcal user
-func LoginGcal(config *GcalConfig, cacheDirName string) (*calendar.Service, error) {
+// opens browser and authenticate as a gcal user,
+// or authenticate as a service account
+//
+// a token is cached in tokenCacheDirPath().
+// a cache in legacyCacheDirName (of older versions) is moved there.
+func LoginGcal(config *GcalConfig, profileName, legacyCacheDirName string) (*calendar.Service, error) {
+	if config.ServiceAccountKey != "" {
+		return loginGcalAsServiceAccount(config)
+	}
+
 	var oauthconfig = &oauth2.Config{
 		ClientID:     config.ClientID,
 		ClientSecret: config.ClientSecret,
@@ -37,7 +63,12 @@ func LoginGcal(config *GcalConfig, cacheDirName string) (*calendar.Service, erro
 		},
 	}
 
-	client := getOAuthClient(oauthconfig, cacheDirName)
+	oauthconfig.Endpoint.DeviceAuthURL = "https://oauth2.googleapis.com/device/code"
+
+	client, err := getOAuthClient(oauthconfig, config, profileName, legacyCacheDirName)
+	if err != nil {
+		return nil, err
+	}
 
 	svc, err := calendar.New(client)
 	if err != nil {
@@ -46,28 +77,64 @@ func LoginGcal(config *GcalConfig, cacheDirName string) (*calendar.Service, erro
 	return svc, err
 }
 
+// a service account, impersonating config.Subject if given
+func loginGcalAsServiceAccount(config *GcalConfig) (*calendar.Service, error) {
+	key, err := ioutil.ReadFile(config.ServiceAccountKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read a service account key: %v", err)
+	}
+
+	jwtconfig, err := google.JWTConfigFromJSON(key, calendar.CalendarScope)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse a service account key: %v", err)
+	}
+	jwtconfig.Subject = config.Subject
+
+	svc, err := calendar.New(jwtconfig.Client(context.Background()))
+	if err != nil {
+		return nil, err
+	}
+	return svc, err
+}
+
 // FetchEventByExtendedProperty ...
 // to fetch an event corresponding to a Garoon event
-func FetchEventByExtendedProperty(gcal *calendar.Service, calendarID string, epexpr string) (*calendar.Event, error) {
-	res, err := gcal.Events.List(calendarID).PrivateExtendedProperty(epexpr).Fields("items(id,summary,description,start,end,recurrence,extendedProperties)", "summary", "nextPageToken").Do()
+func FetchEventByExtendedProperty(ctx context.Context, gcal *calendar.Service, calendarID string, epexprs ...string) (*calendar.Event, error) {
+	res, err := gcal.Events.List(calendarID).PrivateExtendedProperty(epexprs...).Context(ctx).Fields("items(id,summary,description,start,end,recurrence,reminders,status,transparency,visibility,attachments,extendedProperties)", "summary", "nextPageToken").Do()
 	if err != nil {
 		return nil, err
 	}
-	for _, v := range res.Items {
-		//log.Printf("Calendar ID %q event: %v(%v) %v: %q\n", calendarID, v.Id, v.Kind, v.Updated, v.Summary)
-		return v, nil
-	}
+	//for _, v := range res.Items {
+	//	log.Printf("Calendar ID %q event: %v(%v) %v: %q\n", calendarID, v.Id, v.Kind, v.Updated, v.Summary)
+	//}
+
+	return selectGcalEventByKeys(res.Items, epexprs...), nil
+}
 
-	return nil, nil
+// FetchDeletedGcalEventListByDatetime ...
+// fetches events between start and end, including deleted ones
+func FetchDeletedGcalEventListByDatetime(ctx context.Context, gcal *calendar.Service, calendarID string, start time.Time, end time.Time) (*calendar.Events, error) {
+	res, err := gcal.Events.List(calendarID).
+		Context(ctx).
+		TimeMin(start.Local().Format(time.RFC3339)).
+		TimeMax(end.Local().Format(time.RFC3339)).
+		ShowDeleted(true).
+		Fields("items(id,summary,start,end,status,attendees,extendedProperties)", "summary", "nextPageToken").
+		Do()
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
 }
 
 // FetchGcalEventListByDatetime ...
 // fetches events between start and end
-func FetchGcalEventListByDatetime(gcal *calendar.Service, calendarID string, start time.Time, end time.Time) (*calendar.Events, error) {
+func FetchGcalEventListByDatetime(ctx context.Context, gcal *calendar.Service, calendarID string, start time.Time, end time.Time) (*calendar.Events, error) {
 	res, err := gcal.Events.List(calendarID).
+		Context(ctx).
 		TimeMin(start.Local().Format(time.RFC3339)).
 		TimeMax(end.Local().Format(time.RFC3339)).
-		Fields("items(id,summary,description,start,end,recurrence,extendedProperties)", "summary", "nextPageToken").
+		Fields("items(id,summary,description,start,end,recurrence,reminders,status,transparency,visibility,extendedProperties)", "summary", "nextPageToken").
 		Do()
 	if err != nil {
 		return nil, err
@@ -75,61 +142,219 @@ func FetchGcalEventListByDatetime(gcal *calendar.Service, calendarID string, sta
 	return res, nil
 }
 
-func getOAuthClient(oauthconfig *oauth2.Config, cacheDirName string) *http.Client {
-	cacheFile := tokenCacheFile(cacheDirName, oauthconfig)
-	token, err := tokenFromFile(cacheFile)
+func getOAuthClient(oauthconfig *oauth2.Config, config *GcalConfig, profileName, legacyCacheDirName string) (*http.Client, error) {
+	cacheDirName, err := tokenCacheDirPath()
 	if err != nil {
-		token = tokenFromWeb(oauthconfig)
-		saveToken(cacheFile, token)
+		return nil, err
+	}
+	if err := os.MkdirAll(cacheDirName, 0700); err != nil {
+		return nil, err
+	}
+
+	cacheFile := tokenCacheFile(cacheDirName, profileName, oauthconfig)
+	migrateTokenCache(legacyTokenCacheFile(legacyCacheDirName, profileName, oauthconfig), cacheFile)
+
+	token, err := tokenFromFile(cacheFile)
+	if err != nil || config.Reauth {
+		token, err = tokenFromAuthorization(oauthconfig, config)
+		if err != nil {
+			return nil, err
+		}
+		if err := saveToken(cacheFile, token); err != nil {
+			log.Printf("Warning: failed to cache oauth token: %v", err)
+		} else {
+			log.Printf("Saved oauth token to %s", cacheFile)
+		}
 	} else {
 		//log.Printf("Using cached token %#v from %q", token, cacheFile)
 	}
 
-	return oauthconfig.Client(context.Background(), token)
+	ctx := context.Background()
+	ts := &cachingTokenSource{
+		src:  oauthconfig.TokenSource(ctx, token),
+		file: cacheFile,
+		last: token,
+	}
+
+	// fail here, not in every api call
+	if _, err := ts.Token(); err != nil {
+		return nil, err
+	}
+
+	return oauth2.NewClient(ctx, ts), nil
 }
 
-func osUserCacheDir() string {
-	switch runtime.GOOS {
-	case "darwin":
-		return filepath.Join(os.Getenv("HOME"), "Library", "Caches")
-	case "linux", "freebsd":
-		return filepath.Join(os.Getenv("HOME"), ".cache")
+// errGcalReauthorization ...
+// the refresh token is revoked or expired
+var errGcalReauthorization = errors.New("Gcal authorization has been revoked or has expired. Run grn2gcal again with --reauth to authorize again")
+
+// cachingTokenSource ...
+// saves refreshed tokens to a cache file
+type cachingTokenSource struct {
+	src  oauth2.TokenSource
+	file string
+
+	mu   sync.Mutex
+	last *oauth2.Token
+}
+
+func (s *cachingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, err := s.src.Token()
+	if err != nil {
+		var rerr *oauth2.RetrieveError
+		if errors.As(err, &rerr) && rerr.ErrorCode == "invalid_grant" {
+			return nil, errGcalReauthorization
+		}
+		return nil, err
 	}
-	//log.Printf("TODO: osUserCacheDir on GOOS %q", runtime.GOOS)
-	return "."
+
+	if s.last == nil || s.last.AccessToken != token.AccessToken {
+		if err := saveToken(s.file, token); err != nil {
+			log.Printf("Warning: failed to cache oauth token: %v", err)
+		}
+		s.last = token
+	}
+
+	return token, nil
+}
+
+// directory of Gcal token caches.
+//
+//	Windows: %AppData%\grn2gcal
+//	macOS:   ~/Library/Application Support/grn2gcal
+//	others:  $XDG_CONFIG_HOME/grn2gcal or ~/.config/grn2gcal
+func tokenCacheDirPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "grn2gcal"), nil
 }
 
-func tokenCacheFile(dirName string, oauthconfig *oauth2.Config) string {
+func tokenCacheHash(profileName string, oauthconfig *oauth2.Config) uint32 {
 	hash := fnv.New32a()
 	hash.Write([]byte(oauthconfig.ClientID))
 	hash.Write([]byte(oauthconfig.ClientSecret))
 	hash.Write([]byte(oauthconfig.Scopes[0]))
-	fn := fmt.Sprintf("go-api-demo-tok%v", hash.Sum32())
+	if profileName != "" {
+		hash.Write([]byte(profileName))
+	}
+	return hash.Sum32()
+}
+
+func tokenCacheFile(dirName, profileName string, oauthconfig *oauth2.Config) string {
+	fn := fmt.Sprintf("token-%v", tokenCacheHash(profileName, oauthconfig))
+	return filepath.Join(dirName, fn)
+}
+
+// a token cache of older versions (in the config directory)
+func legacyTokenCacheFile(dirName, profileName string, oauthconfig *oauth2.Config) string {
+	fn := fmt.Sprintf("go-api-demo-tok%v", tokenCacheHash(profileName, oauthconfig))
 	return filepath.Join(dirName, url.QueryEscape(fn))
 }
 
+// moves a token cache of older versions to the new location
+func migrateTokenCache(legacyFile, file string) {
+	if _, err := os.Stat(file); err == nil {
+		return
+	}
+	if _, err := os.Stat(legacyFile); err != nil {
+		return
+	}
+
+	token, err := tokenFromFile(legacyFile)
+	if err != nil {
+		log.Printf("Warning: failed to migrate oauth token from %s: %v", legacyFile, err)
+		return
+	}
+	if err := saveToken(file, token); err != nil {
+		log.Printf("Warning: failed to migrate oauth token to %s: %v", file, err)
+		return
+	}
+	os.Remove(legacyFile)
+	log.Printf("Moved oauth token from %s to %s", legacyFile, file)
+}
+
 func tokenFromFile(file string) (*oauth2.Token, error) {
 	f, err := os.Open(file)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 	t := new(oauth2.Token)
 	err = gob.NewDecoder(f).Decode(t)
 	return t, err
 }
 
-func saveToken(file string, token *oauth2.Token) {
-	f, err := os.Create(file)
+// saves a token atomically, readable only by the user
+func saveToken(file string, token *oauth2.Token) error {
+	f, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".*.tmp")
 	if err != nil {
-		log.Printf("Warning: failed to cache oauth token: %v", err)
-		return
+		return err
 	}
-	defer f.Close()
-	gob.NewEncoder(f).Encode(token)
+	defer os.Remove(f.Name())
+
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(token); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), file)
+}
+
+// authorizes this app in a way of authMode
+func tokenFromAuthorization(oauthconfig *oauth2.Config, config *GcalConfig) (*oauth2.Token, error) {
+	authTimeout := config.GetAuthTimeout()
+
+	switch config.AuthMode {
+	case AuthModeBrowser:
+		return tokenFromWeb(oauthconfig, !config.NoBrowser, authTimeout)
+
+	case AuthModeDevice:
+		return tokenFromDevice(oauthconfig, authTimeout)
+
+	case AuthModePaste:
+		return tokenFromPaste(oauthconfig, authTimeout)
+
+	default: // AuthModeAuto
+		if canOpenBrowser() {
+			return tokenFromWeb(oauthconfig, !config.NoBrowser, authTimeout)
+		}
+
+		token, err := tokenFromDevice(oauthconfig, authTimeout)
+		if err == nil {
+			return token, nil
+		}
+		log.Printf("Device authorization is not available (%v). Falling back to pasting a code.", err)
+		return tokenFromPaste(oauthconfig, authTimeout)
+	}
+}
+
+// whether a browser on this machine can be used
+func canOpenBrowser() bool {
+	if os.Getenv("SSH_CONNECTION") != "" || os.Getenv("SSH_TTY") != "" {
+		return false
+	}
+
+	switch runtime.GOOS {
+	case "windows", "darwin":
+		return true
+	}
+	return os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != ""
 }
 
-func tokenFromWeb(oauthconfig *oauth2.Config) *oauth2.Token {
-	ch := make(chan string)
+func tokenFromWeb(oauthconfig *oauth2.Config, opensBrowser bool, authTimeout time.Duration) (*oauth2.Token, error) {
+	ch := make(chan string, 1)
 	randState := fmt.Sprintf("st%d", time.Now().UnixNano())
 	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 		if req.URL.Path == "/favicon.ico" {
@@ -159,18 +384,130 @@ func tokenFromWeb(oauthconfig *oauth2.Config) *oauth2.Token {
 
 	oauthconfig.RedirectURL = ts.URL
 	authURL := oauthconfig.AuthCodeURL(randState)
-	go openURL(authURL)
+	if opensBrowser {
+		go openURL(authURL)
+	}
 	log.Printf("Authorize this app at: %s", authURL)
-	code := <-ch
+
+	var code string
+	select {
+	case code = <-ch:
+	case <-time.After(authTimeout):
+		return nil, fmt.Errorf("timed out waiting for authorization (%v). Try --auth-mode=paste or --auth-mode=device", authTimeout)
+	}
 	//log.Printf("Got code: %s", code)
 
 	token, err := oauthconfig.Exchange(context.Background(), code)
 	if err != nil {
 		//log.Fatalf("Token exchange error: %v", err)
-		log.Printf("Token exchange error: %v", err)
-		return nil
+		return nil, fmt.Errorf("Token exchange error: %v", err)
+	}
+	return token, nil
+}
+
+// OAuth 2.0 device authorization grant.
+// needs a client of "TVs and Limited Input devices" type.
+func tokenFromDevice(oauthconfig *oauth2.Config, authTimeout time.Duration) (*oauth2.Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), authTimeout)
+	defer cancel()
+
+	deviceAuth, err := oauthconfig.DeviceAuth(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	verificationURL := deviceAuth.VerificationURIComplete
+	if verificationURL == "" {
+		verificationURL = deviceAuth.VerificationURI
+	}
+	log.Printf("Visit %s on any device and enter the code: %s", verificationURL, deviceAuth.UserCode)
+
+	token, err := oauthconfig.DeviceAccessToken(ctx, deviceAuth)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("timed out waiting for authorization (%v)", authTimeout)
+		}
+		return nil, err
+	}
+	return token, nil
+}
+
+// out-of-band authorization.
+// the user opens a URL somewhere and pastes the redirected URL (or the code) back.
+func tokenFromPaste(oauthconfig *oauth2.Config, authTimeout time.Duration) (*oauth2.Token, error) {
+	randState := fmt.Sprintf("st%d", time.Now().UnixNano())
+
+	oauthconfig.RedirectURL = pasteRedirectURL
+	authURL := oauthconfig.AuthCodeURL(randState)
+	log.Printf("Authorize this app at: %s", authURL)
+	log.Printf("After authorizing, your browser is redirected to %s and fails to load the page.", pasteRedirectURL)
+	log.Printf("Paste the whole URL in the address bar (or the code in it) here:")
+
+	pasted, err := readLineWithTimeout(stdinLines(), authTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	code := pasted
+	if strings.Contains(pasted, "code=") {
+		u, err := url.Parse(pasted)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the pasted URL: %v", err)
+		}
+		if u.Query().Get("state") != randState {
+			return nil, errors.New("state doesn't match")
+		}
+		code = u.Query().Get("code")
+	}
+	if code == "" {
+		return nil, errors.New("no code")
+	}
+
+	token, err := oauthconfig.Exchange(context.Background(), code)
+	if err != nil {
+		return nil, fmt.Errorf("Token exchange error: %v", err)
+	}
+	return token, nil
+}
+
+// lineReader ...
+// lines of a reader, read by one goroutine for the process lifetime.
+// a line that comes after a timeout is kept for the next read, and no goroutine is left behind.
+type lineReader struct {
+	lines chan string
+	err   error // set before lines is closed
+}
+
+func newLineReader(r io.Reader) *lineReader {
+	lr := &lineReader{lines: make(chan string)}
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lr.lines <- scanner.Text()
+		}
+		lr.err = scanner.Err()
+		if lr.err == nil {
+			lr.err = io.EOF
+		}
+		close(lr.lines)
+	}()
+	return lr
+}
+
+// stdin is read only by this reader, once started
+var stdinLines = sync.OnceValue(func() *lineReader { return newLineReader(os.Stdin) })
+
+// reads a line, giving up after timeout
+func readLineWithTimeout(lr *lineReader, timeout time.Duration) (string, error) {
+	select {
+	case line, ok := <-lr.lines:
+		if !ok {
+			return "", lr.err
+		}
+		return strings.TrimSpace(line), nil
+	case <-time.After(timeout):
+		return "", fmt.Errorf("timed out waiting for authorization (%v)", timeout)
 	}
-	return token
 }
 
 func condDebugTransport(rt http.RoundTripper) http.RoundTripper {
@@ -179,18 +516,21 @@ func condDebugTransport(rt http.RoundTripper) http.RoundTripper {
 }
 
 func openURL(url string) {
-	err := exec.Command("cmd", "/C", "start", "", strings.Replace(url, "&", "^&", -1)).Run()
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("cmd", "/C", "start", "", strings.Replace(url, "&", "^&", -1))
+	case "darwin":
+		cmd = exec.Command("open", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+
+	err := cmd.Run()
 	if err == nil {
 		return
 	}
-	//try := []string{"xdg-open", "google-chrome", "open", "cmd /C start"}
-	//for _, bin := range try {
-	//	err := exec.Command(bin, url).Run()
-	//	if err == nil {
-	//		return
-	//	}
-	//}
-	log.Printf("Error opening URL in browser.")
+	log.Printf("Error opening URL in browser: %v", err)
 }
 
 // debug.go
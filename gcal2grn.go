@@ -0,0 +1,560 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gen2brain/beeep"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+/*
+ * memo
+ *  for each Gcal event (sync.reverse.calendar_id or the sync calendar):
+ *    if it has no ep(garoon_event_id) and matches sync.reverse:
+ *      mark it ep(grn2gcal_pending)
+ *      add a Garoon event, and write back ep(garoon_event_id, gcal_origin)
+ *    if it is still marked ep(grn2gcal_pending) (the last write back failed):
+ *      link the Garoon event added last time instead of adding another one
+ *    if it is gcal_origin and its hash differs from ep(grn2gcal_hash):
+ *      modify the Garoon event
+ */
+
+// syncGcalOrigins ...
+// creates and modifies Garoon events from Gcal-origin events
+func syncGcalOrigins(ctx context.Context, gcal *calendar.Service, gcalCalendarID string, grn *Service, userID string, reverseConfig *ReverseConfig, syncStart, syncEnd time.Time, updm *sync.Mutex) {
+	timeZone := reverseConfig.TimeZone
+	if timeZone == "" {
+		cal, err := gcal.Calendars.Get(gcalCalendarID).Fields("timeZone").Do()
+		if err != nil {
+			log.Printf("Failed to fetch a time zone of a Gcal calendar: %v\n", err)
+			return
+		}
+		timeZone = cal.TimeZone
+	}
+
+	sourceCalendarIDs := []string{gcalCalendarID}
+	if reverseConfig.CalendarID != "" && reverseConfig.CalendarID != gcalCalendarID {
+		sourceCalendarIDs = append(sourceCalendarIDs, reverseConfig.CalendarID)
+	}
+
+	for _, sourceCalendarID := range sourceCalendarIDs {
+		gcalEventList, err := FetchGcalEventListByDatetime(ctx, gcal, sourceCalendarID, syncStart, syncEnd)
+		if err != nil {
+			log.Printf("Failed to fetch a list of Gcal events: %v\n", err)
+			continue
+		}
+
+		for _, gcalEvent := range gcalEventList.Items {
+			ep := map[string]string{}
+			if gcalEvent.ExtendedProperties != nil && gcalEvent.ExtendedProperties.Private != nil {
+				ep = gcalEvent.ExtendedProperties.Private
+			}
+
+			grnEventID, mirrored := ep[gcalEPKeyGaroonEventID]
+			if !mirrored {
+				if !matchesReverseConfig(reverseConfig, sourceCalendarID, gcalEvent) {
+					continue
+				}
+				addGrnEventFromGcal(ctx, gcal, sourceCalendarID, gcalCalendarID, gcalEvent, grn, userID, reverseConfig, timeZone, updm)
+				continue
+			}
+
+			if ep[gcalEPKeyGcalOrigin] == "true" && ep[gcalEPKeySyncHash] != gcalContentHash(gcalEvent) {
+				modifyGrnEventFromGcal(ctx, gcal, sourceCalendarID, gcalEvent, grnEventID, grn, reverseConfig, timeZone, updm)
+			}
+		}
+	}
+}
+
+// whether a Gcal event goes to Garoon
+func matchesReverseConfig(reverseConfig *ReverseConfig, calendarID string, gcalEvent *calendar.Event) bool {
+	if gcalEvent.Start == nil || gcalEvent.Status == "cancelled" {
+		return false
+	}
+
+	if reverseConfig.CalendarID != "" && reverseConfig.CalendarID == calendarID {
+		return true
+	}
+	if reverseConfig.Tag != "" && strings.Contains(gcalEvent.Summary, reverseConfig.Tag) {
+		return true
+	}
+	return false
+}
+
+func addGrnEventFromGcal(ctx context.Context, gcal *calendar.Service, sourceCalendarID, gcalCalendarID string, gcalEvent *calendar.Event, grn *Service, userID string, reverseConfig *ReverseConfig, timeZone string, updm *sync.Mutex) {
+	startDT, endDT, _ := getGcalTimeSpan(gcalEvent)
+	log.Printf("Gcal Event: %s - %s ... %s\n", startDT, endDT, gcalEvent.Summary)
+
+	if len(gcalEvent.Recurrence) > 0 || gcalEvent.RecurringEventId != "" {
+		log.Print("  => Skip (recurring events are not added to Garoon)")
+		return
+	}
+
+	param, err := convertIntoGrnEventParameter(gcalEvent, userID, reverseConfig, timeZone)
+	if err != nil {
+		log.Printf("Failed to convert Gcal event into Garoon event: %v\n", err)
+		return
+	}
+
+	if gcalEvent.ExtendedProperties != nil && gcalEvent.ExtendedProperties.Private[gcalEPKeyGcalPending] == "true" {
+		// added last time, but the Garoon event ID was not written back
+		grnEvent, err := findGrnEventAddedFromGcal(ctx, grn, param)
+		if err != nil {
+			log.Printf("Failed to fetch Garoon events: %v\n", err)
+			return
+		}
+		if grnEvent != nil {
+			log.Print("  => Link Garoon Event")
+			linkGcalEventToGrn(gcal, sourceCalendarID, gcalCalendarID, gcalEvent, grnEvent.ID, updm)
+			return
+		}
+	} else {
+		// mark before adding, not to add twice
+		patch := &calendar.Event{
+			ExtendedProperties: &calendar.EventExtendedProperties{
+				Private: map[string]string{gcalEPKeyGcalPending: "true"},
+			},
+		}
+		updm.Lock()
+		_, err = gcal.Events.Patch(sourceCalendarID, gcalEvent.Id, patch).Do()
+		updm.Unlock()
+		if err != nil {
+			log.Printf("    An error occurred updating a Gcal event: %v\n", err)
+			return
+		}
+	}
+
+	log.Print("  => New Garoon Event")
+
+	result, err := grn.ScheduleAddEvents(ctx, param)
+	if err != nil {
+		log.Printf("    An error occurred adding a Garoon event: %v\n", err)
+		return
+	}
+	if len(result.Events) == 0 || result.Events[0].ID == "" {
+		log.Print("    An error occurred adding a Garoon event: no event id returned")
+		return
+	}
+
+	beeep.Notify("Add Garoon Event", fmt.Sprintf("%v - %v\n%v\n", startDT, endDT, param.Detail), "" /*"assets/information.png"*/)
+
+	linkGcalEventToGrn(gcal, sourceCalendarID, gcalCalendarID, gcalEvent, result.Events[0].ID, updm)
+}
+
+// a Garoon event added from a Gcal event (param) before
+func findGrnEventAddedFromGcal(ctx context.Context, grn *Service, param *GaroonEventParameter) (*GaroonEvent, error) {
+	start, err := time.Parse(time.RFC3339, param.Datetime.Start)
+	if err != nil {
+		return nil, err
+	}
+	end, err := time.Parse(time.RFC3339, param.Datetime.End)
+	if err != nil {
+		return nil, err
+	}
+
+	grnEventList, err := grn.ScheduleGetEvents(ctx, start, end.Add(time.Second))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, grnEvent := range grnEventList.Events {
+		if grnEvent.EventType != GaroonEventTypeNormal || grnEvent.Detail != param.Detail || len(grnEvent.Datetime) == 0 {
+			continue
+		}
+		if !isSameGcalTime(grnEvent.Datetime[0].Start, param.Datetime.Start) || !isSameGcalTime(grnEvent.Datetime[0].End, param.Datetime.End) {
+			continue
+		}
+		for _, m := range grnEvent.Members {
+			if len(param.Members) > 0 && param.Members[0].User != nil && m.ID == param.Members[0].User.ID {
+				return grnEvent, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// write back a Garoon event ID to a Gcal event,
+// and move it to the sync calendar
+func linkGcalEventToGrn(gcal *calendar.Service, sourceCalendarID, gcalCalendarID string, gcalEvent *calendar.Event, grnEventID string, updm *sync.Mutex) {
+	patch := &calendar.Event{
+		ExtendedProperties: &calendar.EventExtendedProperties{
+			Private: map[string]string{
+				gcalEPKeyGaroonEventID: grnEventID,
+				gcalEPKeyGcalOrigin:    "true",
+				gcalEPKeyGcalPending:   "",
+				gcalEPKeySyncHash:      gcalContentHash(gcalEvent),
+			},
+		},
+	}
+
+	updm.Lock()
+	defer updm.Unlock()
+
+	_, err := gcal.Events.Patch(sourceCalendarID, gcalEvent.Id, patch).Do()
+	if err != nil {
+		log.Printf("    An error occurred updating a Gcal event: %v\n", err)
+		return
+	}
+
+	if sourceCalendarID != gcalCalendarID {
+		_, err = gcal.Events.Move(sourceCalendarID, gcalEvent.Id, gcalCalendarID).Do()
+		if err != nil {
+			log.Printf("    An error occurred moving a Gcal event: %v\n", err)
+			return
+		}
+	}
+}
+
+func modifyGrnEventFromGcal(ctx context.Context, gcal *calendar.Service, gcalCalendarID string, gcalEvent *calendar.Event, grnEventID string, grn *Service, reverseConfig *ReverseConfig, timeZone string, updm *sync.Mutex) {
+	startDT, endDT, _ := getGcalTimeSpan(gcalEvent)
+	log.Printf("Gcal Event: %s - %s ... %s\n", startDT, endDT, gcalEvent.Summary)
+
+	grnEventList, err := grn.ScheduleGetEventsByID(ctx, grnEventID)
+	if err != nil {
+		log.Printf("Failed to fetch a Garoon event(ID=%v): %v\n", grnEventID, err)
+		return
+	}
+	if len(grnEventList.Events) == 0 {
+		// to be deleted by syncGcal2Grn
+		return
+	}
+
+	log.Print("  => Change Garoon Event")
+
+	param, err := modifyGrnEventByGcal(ctx, grn, grnEventList.Events[0], gcalEvent, reverseConfig, timeZone)
+	if err != nil {
+		log.Printf("    An error occurred modifying a Garoon event: %v\n", err)
+		return
+	}
+
+	beeep.Notify("Update Garoon Event", fmt.Sprintf("%v - %v\n%v\n", startDT, endDT, param.Detail), "" /*"assets/information.png"*/)
+
+	patch := &calendar.Event{
+		ExtendedProperties: &calendar.EventExtendedProperties{
+			Private: map[string]string{
+				gcalEPKeySyncHash: gcalContentHash(gcalEvent),
+			},
+		},
+	}
+
+	updm.Lock()
+	defer updm.Unlock()
+
+	_, err = gcal.Events.Patch(gcalCalendarID, gcalEvent.Id, patch).Do()
+	if err != nil {
+		log.Printf("    An error occurred updating a Gcal event: %v\n", err)
+		return
+	}
+}
+
+// overwrite a Garoon event with contents of a Gcal event.
+// plan, public type and members stay as they are in Garoon.
+func modifyGrnEventByGcal(ctx context.Context, grn *Service, grnEvent *GaroonEvent, gcalEvent *calendar.Event, reverseConfig *ReverseConfig, timeZone string) (*GaroonEventParameter, error) {
+	param, err := convertIntoGrnModifyParameter(grnEvent, gcalEvent, reverseConfig, timeZone)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = grn.ScheduleModifyEvents(ctx, param)
+	if err != nil {
+		return nil, err
+	}
+	return param, nil
+}
+
+// a Garoon event with contents of a Gcal event.
+// users, organizations and facilities (meeting rooms) are kept,
+// as ScheduleModifyEvents removes members not in the request.
+func convertIntoGrnModifyParameter(grnEvent *GaroonEvent, gcalEvent *calendar.Event, reverseConfig *ReverseConfig, timeZone string) (*GaroonEventParameter, error) {
+	param, err := convertIntoGrnEventParameter(gcalEvent, "", reverseConfig, timeZone)
+	if err != nil {
+		return nil, err
+	}
+	param.ID = grnEvent.ID
+	param.Version = grnEvent.Version
+	param.Plan = grnEvent.Plan
+	param.PublicType = grnEvent.PublicType
+	param.Detail = trimGcalSummaryPlan(grnEvent.Plan, param.Detail)
+	param.Members = param.Members[:0]
+	for _, m := range grnEvent.Members {
+		param.Members = append(param.Members, GaroonMemberParameter{User: &GaroonIDParameter{ID: m.ID}})
+	}
+	for _, m := range grnEvent.Organizations {
+		param.Members = append(param.Members, GaroonMemberParameter{Organization: &GaroonIDParameter{ID: m.ID}})
+	}
+	for _, m := range grnEvent.Facilities {
+		param.Members = append(param.Members, GaroonMemberParameter{Facility: &GaroonIDParameter{ID: m.ID}})
+	}
+
+	return param, nil
+}
+
+// the reverse of formatAsGcalSummary
+func trimGcalSummaryPlan(menu, summary string) string {
+	if menu == "" {
+		return summary
+	}
+
+	prefix := formatAsGcalSummary(menu, "")
+	if strings.HasPrefix(summary, prefix+": ") {
+		return strings.TrimPrefix(summary, prefix+": ")
+	}
+	return strings.TrimPrefix(summary, prefix)
+}
+
+// convert a Gcal event into a Garoon event to add
+func convertIntoGrnEventParameter(gcalEvent *calendar.Event, userID string, reverseConfig *ReverseConfig, timeZone string) (*GaroonEventParameter, error) {
+	detail := gcalEvent.Summary
+	if reverseConfig.Tag != "" {
+		detail = strings.TrimSpace(strings.Replace(detail, reverseConfig.Tag, "", -1))
+	}
+
+	param := &GaroonEventParameter{
+		EventType:   GaroonEventTypeNormal,
+		PublicType:  "public",
+		Plan:        reverseConfig.Plan,
+		Detail:      detail,
+		Description: trimGcalDescriptionSections(gcalEvent.Description),
+		TimeZone:    timeZone,
+		EndTimeZone: timeZone,
+	}
+	if gcalEvent.Visibility == "private" || gcalEvent.Visibility == "confidential" {
+		param.PublicType = "private"
+	}
+
+	param.Members = []GaroonMemberParameter{{User: &GaroonIDParameter{ID: userID}}}
+
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, err
+	}
+
+	if gcalEvent.Start == nil || gcalEvent.End == nil {
+		return nil, fmt.Errorf("no start or end of Gcal event %v", gcalEvent.Id)
+	}
+	if gcalEvent.Start.DateTime != "" {
+		start, err := time.Parse(time.RFC3339, gcalEvent.Start.DateTime)
+		if err != nil {
+			return nil, err
+		}
+		end, err := time.Parse(time.RFC3339, gcalEvent.End.DateTime)
+		if err != nil {
+			return nil, err
+		}
+		param.Datetime.Start = start.UTC().Format(time.RFC3339)
+		param.Datetime.End = end.UTC().Format(time.RFC3339)
+	} else {
+		// Gcal [stt, end) => Garoon all-day [stt 00:00:00, end 23:59:59]
+		start, err := time.ParseInLocation("2006-01-02", gcalEvent.Start.Date, loc)
+		if err != nil {
+			return nil, err
+		}
+		end, err := time.ParseInLocation("2006-01-02", gcalEvent.End.Date, loc)
+		if err != nil {
+			return nil, err
+		}
+		param.AllDay = true
+		param.Datetime.Start = start.UTC().Format(time.RFC3339)
+		param.Datetime.End = end.Add(-time.Second).UTC().Format(time.RFC3339)
+	}
+
+	return param, nil
+}
+
+// a hash of contents of a Gcal event grn2gcal synced.
+// visibility, transparency and reminders count only if not default,
+// so that hashes written before they counted still match.
+func gcalContentHash(gcalEvent *calendar.Event) string {
+	normalize := func(edt *calendar.EventDateTime) string {
+		if edt == nil {
+			return ""
+		}
+		if edt.DateTime == "" {
+			return edt.Date
+		}
+		dt, err := time.Parse(time.RFC3339, edt.DateTime)
+		if err != nil {
+			return edt.DateTime
+		}
+		return dt.UTC().Format(time.RFC3339)
+	}
+
+	hash := sha256.New()
+	for _, v := range []string{
+		gcalEvent.Summary,
+		gcalEvent.Description,
+		normalize(gcalEvent.Start),
+		normalize(gcalEvent.End),
+		strings.Join(gcalEvent.Recurrence, "\n"),
+	} {
+		hash.Write([]byte(v))
+		hash.Write([]byte{0})
+	}
+	if gcalEvent.Visibility != "" && gcalEvent.Visibility != "default" {
+		hash.Write([]byte("visibility=" + gcalEvent.Visibility))
+		hash.Write([]byte{0})
+	}
+	if gcalEvent.Transparency != "" && gcalEvent.Transparency != "opaque" {
+		hash.Write([]byte("transparency=" + gcalEvent.Transparency))
+		hash.Write([]byte{0})
+	}
+	if reminders := formatGcalReminders(gcalEvent.Reminders); reminders != "default" {
+		hash.Write([]byte("reminders=" + reminders))
+		hash.Write([]byte{0})
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil))[:16]
+}
+
+// whether a mirrored Gcal event was edited since grn2gcal wrote it
+func isConflictedGcalEvent(gcalEvent *calendar.Event) bool {
+	if gcalEvent.ExtendedProperties == nil || gcalEvent.ExtendedProperties.Private == nil {
+		return false
+	}
+
+	hash, found := gcalEvent.ExtendedProperties.Private[gcalEPKeySyncHash]
+	if !found {
+		// synced by an older grn2gcal
+		return false
+	}
+	return hash != gcalContentHash(gcalEvent)
+}
+
+// resolveGcalConflict ...
+// resolves a conflict by sync.conflict.
+// returns true if the Gcal event is to be overwritten by the Garoon event.
+func resolveGcalConflict(ctx context.Context, srcEvent *SourceEvent, gcalEvent *calendar.Event, target CalendarTarget, source EventSource, syncConfig *SyncConfig, updm *sync.Mutex) bool {
+	startDT, endDT, _ := getGcalTimeSpan(gcalEvent)
+
+	switch syncConfig.Conflict {
+	case ConflictSkip:
+		log.Print("  => Conflict (edited in Gcal; skipped)")
+		beeep.Notify("Conflict", fmt.Sprintf("%v - %v\n%v\n", startDT, endDT, gcalEvent.Summary), "" /*"assets/warning.png"*/)
+		return false
+
+	case ConflictGoogle:
+		// only single, visible events can be written back
+		writer, writable := source.(EventWriter)
+		if !writable || srcEvent.Kind != SourceEventSingle || (srcEvent.Private && syncConfig.PrivateEvent != "" && syncConfig.PrivateEvent != PrivateEventCopy) {
+			log.Print("  => Conflict (edited in Gcal; cannot be written back to Garoon)")
+			return true
+		}
+
+		err := writer.ModifyEvent(ctx, srcEvent.ID, gcalEvent, &syncConfig.Reverse)
+		if err == errEventNotWritable {
+			log.Print("  => Conflict (edited in Gcal; cannot be written back to Garoon)")
+			return true
+		}
+
+		log.Print("  => Conflict (edited in Gcal; change Garoon Event)")
+
+		if err != nil {
+			log.Printf("    An error occurred modifying a Garoon event: %v\n", err)
+			return false
+		}
+
+		beeep.Notify("Update Garoon Event", fmt.Sprintf("%v - %v\n%v\n", startDT, endDT, gcalEvent.Summary), "" /*"assets/information.png"*/)
+
+		gcalEvent.ExtendedProperties.Private[gcalEPKeySyncHash] = gcalContentHash(gcalEvent)
+
+		updm.Lock()
+		defer updm.Unlock()
+
+		err = target.Update(ctx, gcalEvent)
+		if err != nil {
+			log.Printf("    An error occurred updating a Gcal event: %v\n", err)
+		}
+		return false
+
+	default: // garoon
+		log.Print("  => Conflict (edited in Gcal; overwritten)")
+		return true
+	}
+}
+
+// syncGcalDeclines ...
+// handles mirrored events the user deleted or declined in Gcal.
+// returns Garoon event IDs not to be re-created in Gcal.
+func syncGcalDeclines(ctx context.Context, gcal *calendar.Service, gcalCalendarID string, source EventWriter, userID string, syncConfig *SyncConfig, syncStart, syncEnd time.Time) map[string]bool {
+	declined := map[string]bool{}
+
+	gcalEventList, err := FetchDeletedGcalEventListByDatetime(ctx, gcal, gcalCalendarID, syncStart, syncEnd)
+	if err != nil {
+		log.Printf("Failed to fetch a list of Gcal events: %v\n", err)
+		return declined
+	}
+
+	for _, gcalEvent := range gcalEventList.Items {
+		if gcalEvent.ExtendedProperties == nil || gcalEvent.ExtendedProperties.Private == nil {
+			continue
+		}
+		ep := gcalEvent.ExtendedProperties.Private
+
+		grnEventID, mirrored := ep[gcalEPKeyGaroonEventID]
+		if !mirrored || declined[grnEventID] || !isDeclinedGcalEvent(gcalEvent) {
+			continue
+		}
+
+		srcEvent, err := source.GetEvent(ctx, grnEventID)
+		if err != nil {
+			log.Printf("Failed to fetch a Garoon event(ID=%v): %v\n", grnEventID, err)
+			continue
+		}
+		if !isAttendeeOfSourceEvent(userID, srcEvent) {
+			// already left
+			continue
+		}
+		if isSkippedSourceEvent(srcEvent, syncConfig) {
+			// deleted in Gcal by grn2gcal itself
+			continue
+		}
+
+		startDT, endDT, _ := getGcalTimeSpan(gcalEvent)
+		log.Printf("Garoon Event: %v - %v ... %v %v\n", startDT, endDT, srcEvent.Title, grnEventID)
+
+		declined[grnEventID] = true
+
+		// a candidate of a temporary event, or reporting only
+		if _, found := ep[gcalEPKeyGaroonCandidate]; found || syncConfig.Decline == DeclineReport {
+			log.Print("  => Declined in Gcal (report)")
+			beeep.Notify("Declined Garoon Event", fmt.Sprintf("%v - %v\n%v\n", startDT, endDT, srcEvent.Title), "" /*"assets/information.png"*/)
+			continue
+		}
+
+		log.Print("  => Declined in Gcal (leave Garoon Event)")
+
+		err = source.LeaveEvent(ctx, grnEventID)
+		if err != nil {
+			log.Printf("    An error occurred leaving a Garoon event: %v\n", err)
+			continue
+		}
+
+		beeep.Notify("Leave Garoon Event", fmt.Sprintf("%v - %v\n%v\n", startDT, endDT, srcEvent.Title), "" /*"assets/information.png"*/)
+	}
+
+	return declined
+}
+
+// whether the user deleted or declined a mirrored Gcal event.
+// events without grn2gcal_hash were not synced by this version, and are left alone.
+func isDeclinedGcalEvent(gcalEvent *calendar.Event) bool {
+	if gcalEvent.ExtendedProperties == nil || gcalEvent.ExtendedProperties.Private[gcalEPKeySyncHash] == "" {
+		return false
+	}
+
+	if gcalEvent.Status == "cancelled" {
+		return gcalEvent.ExtendedProperties.Private[gcalEPKeyDeleted] != "true"
+	}
+
+	for _, a := range gcalEvent.Attendees {
+		if a.Self && a.ResponseStatus == "declined" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// a Garoon answering every request with a recorded response
+func newFixtureGaroonServer(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+		http.ServeFile(w, r, filepath.Join("testdata", name))
+	}))
+}
+
+func TestFindGrnEventAddedFromGcal(t *testing.T) {
+	server := newFixtureGaroonServer(t, "ScheduleGetEventsResponse_allday.xml")
+	defer server.Close()
+	grn := NewGaroon("user", "password", server.URL, server.Client())
+
+	param := &GaroonEventParameter{Detail: "定例"}
+	param.Members = []GaroonMemberParameter{{User: &GaroonIDParameter{ID: "7"}}}
+	param.Datetime.Start = "2020-06-05T01:00:00Z"
+	param.Datetime.End = "2020-06-05T02:00:00Z"
+
+	grnEvent, err := findGrnEventAddedFromGcal(context.Background(), grn, param)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grnEvent == nil || grnEvent.ID != "202" {
+		t.Errorf("found = %+v, want 202", grnEvent)
+	}
+
+	// another title, another time, another user
+	for _, modify := range []func(p *GaroonEventParameter){
+		func(p *GaroonEventParameter) { p.Detail = "定例会" },
+		func(p *GaroonEventParameter) { p.Datetime.End = "2020-06-05T03:00:00Z" },
+		func(p *GaroonEventParameter) { p.Members[0].User = &GaroonIDParameter{ID: "8"} },
+	} {
+		other := *param
+		other.Members = []GaroonMemberParameter{param.Members[0]}
+		modify(&other)
+
+		grnEvent, err := findGrnEventAddedFromGcal(context.Background(), grn, &other)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if grnEvent != nil {
+			t.Errorf("found %s for %+v", grnEvent.ID, other)
+		}
+	}
+}
+
+func TestConvertIntoGrnModifyParameterKeepsMembers(t *testing.T) {
+	var result ScheduleGetEventsByIDResult
+	loadGaroonFixture(t, "ScheduleGetEventsByIdResponse_facility.xml", &result)
+	grnEvent := result.Events[0]
+
+	gcalEvent := &calendar.Event{
+		Summary: "<会議>: 週次定例 (時間変更)",
+		Start:   &calendar.EventDateTime{DateTime: "2020-06-08T11:00:00+09:00"},
+		End:     &calendar.EventDateTime{DateTime: "2020-06-08T12:00:00+09:00"},
+	}
+	param, err := convertIntoGrnModifyParameter(grnEvent, gcalEvent, &ReverseConfig{}, "Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if param.ID != "301" || param.Version != "1590000021" || param.Detail != "週次定例 (時間変更)" {
+		t.Errorf("param = %s %s %q", param.ID, param.Version, param.Detail)
+	}
+
+	marshaled, err := xml.Marshal(param)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, member := range []string{
+		`<member><user id="7"></user></member>`,
+		`<member><user id="8"></user></member>`,
+		`<member><organization id="3"></organization></member>`,
+		`<member><facility id="12"></facility></member>`,
+	} {
+		if !strings.Contains(string(marshaled), member) {
+			t.Errorf("no %s in %s", member, marshaled)
+		}
+	}
+}
+
+func TestIsDeclinedGcalEvent(t *testing.T) {
+	mirrored := func(status string, ep map[string]string, attendees ...*calendar.EventAttendee) *calendar.Event {
+		private := map[string]string{gcalEPKeyGaroonEventID: "301"}
+		for k, v := range ep {
+			private[k] = v
+		}
+		return &calendar.Event{
+			Status:             status,
+			Attendees:          attendees,
+			ExtendedProperties: &calendar.EventExtendedProperties{Private: private},
+		}
+	}
+	synced := map[string]string{gcalEPKeySyncHash: "abc"}
+
+	for _, c := range []struct {
+		name  string
+		event *calendar.Event
+		want  bool
+	}{
+		{"deleted by the user", mirrored("cancelled", synced), true},
+		{"declined by the user", mirrored("confirmed", synced, &calendar.EventAttendee{Self: true, ResponseStatus: "declined"}), true},
+		{"accepted", mirrored("confirmed", synced, &calendar.EventAttendee{Self: true, ResponseStatus: "accepted"}), false},
+		{"deleted by grn2gcal", mirrored("cancelled", map[string]string{gcalEPKeySyncHash: "abc", gcalEPKeyDeleted: "true"}), false},
+		{"deleted, synced by an older version", mirrored("cancelled", nil), false},
+		{"declined, synced by an older version", mirrored("confirmed", nil, &calendar.EventAttendee{Self: true, ResponseStatus: "declined"}), false},
+	} {
+		if got := isDeclinedGcalEvent(c.event); got != c.want {
+			t.Errorf("%s: %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestGcalContentHash(t *testing.T) {
+	base := func() *calendar.Event {
+		return &calendar.Event{
+			Summary: "<会議>: 定例",
+			Start:   &calendar.EventDateTime{DateTime: "2020-06-08T10:00:00+09:00"},
+			End:     &calendar.EventDateTime{DateTime: "2020-06-08T11:00:00+09:00"},
+		}
+	}
+	hash := gcalContentHash(base())
+
+	// defaults and the same times elsewhere
+	for _, modify := range []func(e *calendar.Event){
+		func(e *calendar.Event) { e.Visibility = "default" },
+		func(e *calendar.Event) { e.Transparency = "opaque" },
+		func(e *calendar.Event) { e.Reminders = &calendar.EventReminders{UseDefault: true} },
+		func(e *calendar.Event) { e.Start.DateTime = "2020-06-08T01:00:00Z" },
+	} {
+		e := base()
+		modify(e)
+		if h := gcalContentHash(e); h != hash {
+			t.Errorf("%+v: hash changed", e)
+		}
+	}
+
+	// edited
+	for _, modify := range []func(e *calendar.Event){
+		func(e *calendar.Event) { e.Summary = "<会議>: 臨時" },
+		func(e *calendar.Event) { e.Description = "memo" },
+		func(e *calendar.Event) { e.End.DateTime = "2020-06-08T12:00:00+09:00" },
+		func(e *calendar.Event) { e.Recurrence = []string{"RRULE:FREQ=WEEKLY"} },
+		func(e *calendar.Event) { e.Visibility = "private" },
+		func(e *calendar.Event) { e.Transparency = "transparent" },
+		func(e *calendar.Event) {
+			e.Reminders = &calendar.EventReminders{Overrides: []*calendar.EventReminder{{Method: "popup", Minutes: 5}}}
+		},
+	} {
+		e := base()
+		modify(e)
+		if h := gcalContentHash(e); h == hash {
+			t.Errorf("%+v: hash not changed", e)
+		}
+	}
+}
+
+// writerSource ...
+// a memorySource recording ModifyEvent
+type writerSource struct {
+	memorySource
+	modified []string
+	err      error
+}
+
+func (s *writerSource) ModifyEvent(ctx context.Context, id string, gcalEvent *calendar.Event, reverseConfig *ReverseConfig) error {
+	s.modified = append(s.modified, id)
+	return s.err
+}
+
+func (s *writerSource) LeaveEvent(ctx context.Context, id string) error {
+	return nil
+}
+
+func TestResolveGcalConflict(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		conflict  string
+		kind      string
+		readOnly  bool
+		err       error
+		overwrite bool // want the Gcal event overwritten
+		modified  bool // want written back
+	}{
+		{name: "garoon", conflict: ConflictGaroon, kind: SourceEventSingle, overwrite: true},
+		{name: "default", conflict: "", kind: SourceEventSingle, overwrite: true},
+		{name: "skip", conflict: ConflictSkip, kind: SourceEventSingle},
+		{name: "google", conflict: ConflictGoogle, kind: SourceEventSingle, modified: true},
+		{name: "google, failed", conflict: ConflictGoogle, kind: SourceEventSingle, err: errors.New("unavailable"), modified: true},
+		{name: "google, not writable", conflict: ConflictGoogle, kind: SourceEventSingle, err: errEventNotWritable, overwrite: true, modified: true},
+		{name: "google, tentative", conflict: ConflictGoogle, kind: SourceEventTentative, overwrite: true},
+		{name: "google, read-only source", conflict: ConflictGoogle, kind: SourceEventSingle, readOnly: true, overwrite: true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			stub, server := newCalDAVStub(t)
+			target := newCalDAVTarget(&CalDAVConfig{URL: server.URL + "/calendars/user/conflict"})
+			ctx := context.Background()
+
+			srcEvent := &SourceEvent{ID: "c1", Kind: c.kind, Title: "定例"}
+			inserted := &calendar.Event{
+				Summary: "<会議>: 定例",
+				Start:   &calendar.EventDateTime{DateTime: "2020-06-08T01:00:00Z"},
+				End:     &calendar.EventDateTime{DateTime: "2020-06-08T02:00:00Z"},
+				ExtendedProperties: &calendar.EventExtendedProperties{Private: map[string]string{
+					gcalEPKeyGaroonEventID: "c1",
+				}},
+			}
+			inserted.ExtendedProperties.Private[gcalEPKeySyncHash] = gcalContentHash(inserted)
+			if err := target.Insert(ctx, inserted); err != nil {
+				t.Fatal(err)
+			}
+
+			// edited in Gcal
+			edited, err := target.FindBySourceID(ctx, "garoon_event_id=c1")
+			if err != nil || edited == nil {
+				t.Fatalf("FindBySourceID = %v, %v", edited, err)
+			}
+			edited.Summary = "<会議>: 定例 (変更)"
+			if err := target.Update(ctx, edited); err != nil {
+				t.Fatal(err)
+			}
+			gcalEvent, err := target.FindBySourceID(ctx, "garoon_event_id=c1")
+			if err != nil || !isConflictedGcalEvent(gcalEvent) {
+				t.Fatalf("not conflicted: %v", err)
+			}
+
+			writer := &writerSource{err: c.err}
+			var source EventSource = writer
+			if c.readOnly {
+				source = &writer.memorySource
+			}
+
+			var updm sync.Mutex
+			overwrite := resolveGcalConflict(ctx, srcEvent, gcalEvent, target, source, &SyncConfig{Conflict: c.conflict}, &updm)
+			if overwrite != c.overwrite {
+				t.Errorf("overwrite = %v", overwrite)
+			}
+			if modified := len(writer.modified) > 0; modified != c.modified {
+				t.Errorf("modified = %v", modified)
+			}
+
+			// written back; the Gcal edit is no longer a conflict
+			written := c.modified && c.err == nil
+			stored, err := target.FindBySourceID(ctx, "garoon_event_id=c1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if conflicted := isConflictedGcalEvent(stored); conflicted == written {
+				t.Errorf("conflicted = %v after resolution (%d resources)", conflicted, len(stub.resources))
+			}
+		})
+	}
+}
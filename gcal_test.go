@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestReadLineWithTimeout(t *testing.T) {
+	// a plain reader without deadline support, like stdin on a TTY
+	r, w := io.Pipe()
+	lr := newLineReader(r)
+
+	if _, err := readLineWithTimeout(lr, 50*time.Millisecond); err == nil {
+		t.Error("no error without input")
+	}
+
+	// a line after the timeout is read by the same goroutine
+	go w.Write([]byte(" http://localhost/?code=abc \n"))
+	line, err := readLineWithTimeout(lr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "http://localhost/?code=abc" {
+		t.Errorf("line = %q", line)
+	}
+
+	w.Close()
+	if _, err := readLineWithTimeout(lr, time.Second); err != io.EOF {
+		t.Errorf("err = %v at the end, want EOF", err)
+	}
+}
+
+func TestSaveToken(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "token-1")
+	token := &oauth2.Token{AccessToken: "a1", RefreshToken: "r1", Expiry: time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)}
+
+	// saved twice, over an older token
+	for i := 0; i < 2; i++ {
+		if err := saveToken(file, token); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loaded, err := tokenFromFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.AccessToken != "a1" || loaded.RefreshToken != "r1" || !loaded.Expiry.Equal(token.Expiry) {
+		t.Errorf("loaded = %+v", loaded)
+	}
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode != 0600 {
+			t.Errorf("mode = %v, want 0600", mode)
+		}
+	}
+
+	// no temporary files left
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 1 {
+		t.Errorf("files = %v", files)
+	}
+}
+
+// a token endpoint issuing a1, a2, ... for refresh token r1, or invalid_grant if revoked
+func newTokenServer(t *testing.T, revoked *atomic.Bool) *httptest.Server {
+	t.Helper()
+
+	var issued atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		if revoked.Load() || r.Form.Get("refresh_token") != "r1" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant","error_description":"Token has been expired or revoked."}`)
+			return
+		}
+		fmt.Fprintf(w, `{"access_token":"a%d","token_type":"Bearer","expires_in":3600}`, issued.Add(1))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCachingTokenSource(t *testing.T) {
+	var revoked atomic.Bool
+	server := newTokenServer(t, &revoked)
+	oauthconfig := &oauth2.Config{ClientID: "id", Endpoint: oauth2.Endpoint{TokenURL: server.URL}}
+
+	file := filepath.Join(t.TempDir(), "token-1")
+	expired := &oauth2.Token{AccessToken: "a0", RefreshToken: "r1", Expiry: time.Now().Add(-time.Hour)}
+	if err := saveToken(file, expired); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	ts := &cachingTokenSource{src: oauthconfig.TokenSource(ctx, expired), file: file, last: expired}
+
+	// refreshed and written back
+	token, err := ts.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := tokenFromFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "a1" || cached.AccessToken != "a1" || cached.RefreshToken != "r1" {
+		t.Errorf("token = %s, cached = %s %s, want a1 r1", token.AccessToken, cached.AccessToken, cached.RefreshToken)
+	}
+
+	// still valid; not written again
+	os.Remove(file)
+	if token, err := ts.Token(); err != nil || token.AccessToken != "a1" {
+		t.Errorf("token = %v, %v", token, err)
+	}
+	if _, err := os.Stat(file); err == nil {
+		t.Error("written without a refresh")
+	}
+
+	// revoked
+	revoked.Store(true)
+	ts = &cachingTokenSource{src: oauthconfig.TokenSource(ctx, expired), file: file, last: expired}
+	if _, err := ts.Token(); err != errGcalReauthorization {
+		t.Errorf("err = %v, want errGcalReauthorization", err)
+	}
+}
+
+func TestMigrateTokenCache(t *testing.T) {
+	oauthconfig := &oauth2.Config{ClientID: "id", ClientSecret: "secret", Scopes: []string{"scope"}}
+	legacyDir, dir := t.TempDir(), t.TempDir()
+	legacyFile := legacyTokenCacheFile(legacyDir, "work", oauthconfig)
+	file := tokenCacheFile(dir, "work", oauthconfig)
+
+	if err := saveToken(legacyFile, &oauth2.Token{AccessToken: "legacy", RefreshToken: "r1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// moved
+	migrateTokenCache(legacyFile, file)
+	token, err := tokenFromFile(file)
+	if err != nil || token.AccessToken != "legacy" {
+		t.Fatalf("token = %v, %v after migration", token, err)
+	}
+	if _, err := os.Stat(legacyFile); err == nil {
+		t.Error("legacy cache left")
+	}
+
+	// a newer cache is kept
+	if err := saveToken(legacyFile, &oauth2.Token{AccessToken: "older"}); err != nil {
+		t.Fatal(err)
+	}
+	migrateTokenCache(legacyFile, file)
+	if token, _ := tokenFromFile(file); token.AccessToken != "legacy" {
+		t.Errorf("token = %s, want kept", token.AccessToken)
+	}
+
+	// nothing to migrate
+	other := tokenCacheFile(dir, "home", oauthconfig)
+	migrateTokenCache(legacyTokenCacheFile(legacyDir, "home", oauthconfig), other)
+	if _, err := os.Stat(other); err == nil {
+		t.Error("created without a legacy cache")
+	}
+}
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
+	"flag"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -22,8 +29,35 @@ const (
 	gcalEPKeyGaroonEventID string = "garoon_event_id"
 	configDirName          string = ".grn2gcal"
 	configFileName         string = "config.json"
+
+	// index of candidates of a temporary Garoon event
+	gcalEPKeyGaroonCandidate string = "garoon_candidate"
+	// "true" if the Garoon event has no end
+	gcalEPKeyGaroonStartOnly string = "garoon_start_only"
+	// "true" if the Gcal event is added to Garoon by grn2gcal
+	gcalEPKeyGcalOrigin string = "gcal_origin"
+	// "true" while grn2gcal adds the Gcal event to Garoon
+	gcalEPKeyGcalPending string = "grn2gcal_pending"
+	// a hash of contents grn2gcal synced last
+	gcalEPKeySyncHash string = "grn2gcal_hash"
+	// "true" if grn2gcal deleted the Gcal event (not the user)
+	gcalEPKeyDeleted string = "grn2gcal_deleted"
+
+	// a summary of private events with sync.private_event=busy
+	privateEventBusySummary string = "Busy"
+	// the beginning of follow-ups in a Gcal description
+	gcalFollowsDelimiter string = "----- Garoon follow-ups -----"
+	// the beginning of attachments in a Gcal description
+	gcalAttachmentsDelimiter string = "----- Garoon attachments -----"
 )
 
+// extended properties updated by Garoon events
+var gcalEPOwnedKeys = []string{
+	gcalEPKeyGaroonEventID,
+	gcalEPKeyGaroonCandidate,
+	gcalEPKeyGaroonStartOnly,
+}
+
 /*
  * memo
  *  for each Garoon event:
@@ -44,11 +78,46 @@ const (
 var log = rog.New(os.Stderr, "", rog.Ltime /*|rog.Lshortfile*/)
 
 func main() {
-	fmt.Println("TODO:")
-	fmt.Println("  - コードの構造を整理する")
-	fmt.Println("  - 繰り返しイベントを登録、検知する")
-	fmt.Println("")
-	//fmt.Println("  - ")
+	authMode := flag.String("auth-mode", "", "how to authorize Gcal access: auto, browser, device or paste")
+	reauth := flag.Bool("reauth", false, "authorize Gcal access again, discarding a cached token")
+	noBrowser := flag.Bool("no-browser", false, "do not open a browser to authorize Gcal access, only show the URL")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] [command]\n\n", filepath.Base(os.Args[0]))
+		fmt.Fprintln(flag.CommandLine.Output(), "Commands:")
+		fmt.Fprintln(flag.CommandLine.Output(), "  sync   sync Garoon events into Gcal (default)")
+		fmt.Fprintln(flag.CommandLine.Output(), "  check  check the connection to Garoon")
+		fmt.Fprintln(flag.CommandLine.Output(), "  export ics [-o file]")
+		fmt.Fprintln(flag.CommandLine.Output(), "         export Garoon events into an iCalendar file (default: stdout)")
+		fmt.Fprintln(flag.CommandLine.Output(), "  serve  serve Garoon events at /calendar.ics?token=... (see the serve config)")
+		fmt.Fprintln(flag.CommandLine.Output(), "\nOptions:")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	command := flag.Arg(0)
+	var icsOutput string
+	switch command {
+	case "", "sync", "check", "serve":
+	case "export":
+		if flag.Arg(1) != "ics" {
+			flag.Usage()
+			os.Exit(2)
+		}
+		exportFlags := flag.NewFlagSet("export ics", flag.ExitOnError)
+		exportFlags.StringVar(&icsOutput, "o", "", "output file (default: stdout)")
+		exportFlags.Parse(flag.Args()[2:])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if command != "export" && command != "serve" {
+		fmt.Println("TODO:")
+		fmt.Println("  - コードの構造を整理する")
+		fmt.Println("  - 繰り返しイベントを登録、検知する")
+		fmt.Println("")
+		//fmt.Println("  - ")
+	}
 
 	configDirPath := filepath.Join(homeDirPath(), configDirName)
 	configFilePath := filepath.Join(configDirPath, configFileName)
@@ -78,88 +147,251 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *authMode != "" {
+		config.Gcal.AuthMode = *authMode
+	}
+	config.Gcal.Reauth = *reauth
+	if *noBrowser {
+		config.Gcal.NoBrowser = true
+	}
+
 	if err := ValidateConfig(config); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	if command == "" || command == "sync" {
+		if err := ValidateGcalConfig(config); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
 
-	grn := NewGaroon(config.Garoon.Account, config.Garoon.Password, config.Garoon.BaseURL)
+	profiles := config.Profiles
+	if len(profiles) == 0 {
+		profiles = []ProfileConfig{{}}
+	}
 
-	// get Garoon user id
+	if command == "export" {
+		if err := exportICS(config, profiles, icsOutput); err != nil {
+			log.Print(err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	targetUser, err := grn.UtilGetLoginUserID()
-	if err != nil {
-		log.Printf("Failed to access to Garoon : %v", err)
+	if command == "serve" {
+		if err := serveICS(config, profiles); err != nil {
+			log.Print(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	failed := false
+	for i := range profiles {
+		if profiles[i].Name != "" {
+			fmt.Printf("profile: %v\n", profiles[i].Name)
+		}
+
+		profileConfig := config.ApplyProfile(&profiles[i])
+
+		var err error
+		switch command {
+		case "check":
+			err = checkProfile(&profileConfig)
+		default:
+			err = syncProfile(&profileConfig, profiles[i].Name, configDirPath)
+		}
+		if err != nil {
+			log.Print(err)
+			failed = true
+		}
+	}
+	if failed {
 		os.Exit(1)
 	}
-	fmt.Printf("user_id: %v\n", targetUser.UserID)
+}
+
+// exports Garoon events of all profiles into an iCalendar file
+func exportICS(config *Config, profiles []ProfileConfig, output string) error {
+	ctx := context.Background()
+	syncStart, syncEnd := syncSpan(time.Now())
+
+	cal := newICSCalendar(time.Now())
+	for i := range profiles {
+		if profiles[i].Name != "" {
+			log.Printf("profile: %v\n", profiles[i].Name)
+		}
+
+		profileConfig := config.ApplyProfile(&profiles[i])
+		if err := fetchICSEvents(ctx, &profileConfig, cal, syncStart, syncEnd); err != nil {
+			return err
+		}
+	}
 
-	// Google Calendar login (borrowed from sample codes)
+	if output == "" {
+		_, err := cal.WriteTo(os.Stdout)
+		return err
+	}
 
-	gcal, err := LoginGcal(&config.Gcal, configDirPath)
+	file, err := os.Create(output)
 	if err != nil {
-		log.Print(err)
-		os.Exit(1)
+		return err
+	}
+	if _, err := cal.WriteTo(file); err != nil {
+		file.Close()
+		return err
 	}
+	return file.Close()
+}
+
+// the span of Garoon events to sync
+func syncSpan(now time.Time) (time.Time, time.Time) {
+	return now, //FirstDayOfMonth(now).AddDate(0, -1, 0)
+		LastDayOfMonth(now).AddDate(0, +2, 0)
+}
 
-	//listRes, err := gcal.CalendarList.List().Fields("items/id").Do()
-	listRes, err := gcal.CalendarList.List().Fields("items(id,accessRole,deleted,primary,selected)").Do()
+// creates a Garoon service of a config
+func newGaroonService(config *Config) (*Service, error) {
+	grnClient, err := NewGaroonHTTPClient(&config.Garoon)
 	if err != nil {
-		log.Printf("Failed to fetch a list of Gcal calendars: %v", err)
-		os.Exit(1)
+		return nil, err
 	}
-	//gcalCalendarID := listRes.Items[0].Id
-	var gcalCalendarID string
-	for _, c := range listRes.Items {
-		if c.Primary {
-			gcalCalendarID = c.Id
-			break
+	grn := NewGaroon(config.Garoon.Account, config.Garoon.Password, config.Garoon.BaseURL, grnClient)
+	grn.Locale = config.Garoon.GetLocale()
+	grn.Auth = NewGaroonAuth(&config.Garoon)
+	if config.Garoon.TimestampValidity != "" {
+		grn.TimestampValidity, _ = time.ParseDuration(config.Garoon.TimestampValidity)
+	}
+
+	return grn, nil
+}
+
+// check TLS (including a client certificate) and authentication of Garoon
+func checkProfile(config *Config) error {
+	ctx := context.Background()
+
+	grn, err := newGaroonService(config)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(strings.ToLower(grn.BaseURL), "https:") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, grn.BaseURL, nil)
+		if err != nil {
+			return err
+		}
+		res, err := grn.Client.Do(req)
+		if err != nil {
+			return fmt.Errorf("TLS: NG: %v", err)
+		}
+		res.Body.Close()
+
+		if res.TLS == nil || len(res.TLS.PeerCertificates) == 0 {
+			return errors.New("TLS: NG: no server certificates")
 		}
+		fmt.Printf("TLS: OK (%s, %s, %v)\n", tls.VersionName(res.TLS.Version), res.TLS.PeerCertificates[0].Subject, res.Status)
+	} else {
+		fmt.Println("TLS: not used")
 	}
-	if gcalCalendarID == "" {
-		log.Print("No primary calendar.")
-		os.Exit(1)
+
+	targetUser, err := grn.UtilGetLoginUserID(ctx)
+	if err != nil {
+		return fmt.Errorf("UtilGetLoginUserId: NG: %v", err)
+	}
+	if targetUser.UserID == "" {
+		return errors.New("UtilGetLoginUserId: NG: no user_id (authentication failed?)")
 	}
+	fmt.Printf("UtilGetLoginUserId: OK (user_id: %v)\n", targetUser.UserID)
 
-	// List Garoon events
+	return nil
+}
+
+// sync a Garoon user and a Gcal user
+func syncProfile(config *Config, profileName, configDirPath string) error {
+	ctx := context.Background()
 
-	syncStart := time.Now() //FirstDayOfMonth(time.Now()).AddDate(0, -1, 0)
-	syncEnd := LastDayOfMonth(time.Now()).AddDate(0, +2, 0)
-	grnEventList, err := grn.ScheduleGetEvents(syncStart, syncEnd)
+	source, err := newEventSource(config)
 	if err != nil {
-		log.Print(err)
-		os.Exit(1)
+		return err
 	}
 
-	fmt.Println("------------")
+	// get Garoon user id
+
+	userID, err := source.CurrentUser(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to access to Garoon : %v", err)
+	}
+	fmt.Printf("user_id: %v\n", userID)
+
+	// a calendar to sync into
+
+	var target CalendarTarget
+	var gcal *gcalTarget
+	if config.CalDAV.URL != "" {
+		target = newCalDAVTarget(&config.CalDAV)
+	} else {
+		gcal, err = newGcalTarget(&config.Gcal, profileName, configDirPath)
+		if err != nil {
+			return err
+		}
+		target = gcal
+	}
+
+	syncStart, syncEnd := syncSpan(time.Now())
 
 	var wg sync.WaitGroup
 	var updm sync.Mutex
-	for _, grnEvent := range grnEventList.Events {
-		if !isMemberOfGrnEvent(targetUser.UserID, grnEvent) {
+
+	// Gcal => Garoon (new events and changes made in Gcal)
+
+	if grn, ok := source.(garoonBacked); gcal != nil && ok && config.Sync.Reverse.Enabled {
+		syncGcalOrigins(ctx, gcal.Service, gcal.CalendarID, grn.garoon(), userID, &config.Sync.Reverse, syncStart, syncEnd, &updm)
+	}
+
+	// Gcal => Garoon (events deleted or declined in Gcal)
+
+	declined := map[string]bool{}
+	if writer, ok := source.(EventWriter); gcal != nil && ok && (config.Sync.Decline == DeclineReport || config.Sync.Decline == DeclineLeave) {
+		declined = syncGcalDeclines(ctx, gcal.Service, gcal.CalendarID, writer, userID, &config.Sync, syncStart, syncEnd)
+	}
+
+	// List Garoon events
+
+	srcEvents, err := source.ListEvents(ctx, syncStart, syncEnd)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("------------")
+
+	for _, srcEvent := range srcEvents {
+		if !isAttendeeOfSourceEvent(userID, srcEvent) || declined[srcEvent.ID] {
 			continue
 		}
 
-		if strings.HasPrefix(grnEvent.Detail, "*") {
+		if isSkippedSourceEvent(srcEvent, &config.Sync) {
 			continue
 		}
 
-		go syncGrn2Gcal(grnEvent, gcal, gcalCalendarID, &wg, &updm)
+		go syncGrn2Gcal(ctx, srcEvent, target, source, &config.Sync, &wg, &updm)
 	}
 	wg.Wait()
 
 	// list Gcal events
 
 	log.Printf("Deletion check")
-	gcalgrnEventList, err := FetchGcalEventListByDatetime(gcal, gcalCalendarID, syncStart, syncEnd)
+	gcalgrnEventList, err := target.List(ctx, syncStart, syncEnd)
 	if err != nil {
 		log.Printf("Failed to fetch a list of Gcal calendars: %v\n", err)
 	} else {
-		for i := range gcalgrnEventList.Items {
-			go syncGcal2Grn(gcalgrnEventList.Items[i], gcal, gcalCalendarID, grn, targetUser, &wg, &updm)
+		for i := range gcalgrnEventList {
+			go syncGcal2Grn(ctx, gcalgrnEventList[i], target, source, userID, &config.Sync, &wg, &updm)
 		}
 	}
 	wg.Wait()
+
+	return nil
 }
 
 func isEqualGcalEvent(grnGcalEvent, gcalEvent *calendar.Event) (bool, string) {
@@ -167,25 +399,27 @@ func isEqualGcalEvent(grnGcalEvent, gcalEvent *calendar.Event) (bool, string) {
 		return false, "nil"
 	}
 
-	// ep(grnEvent.ID)
+	// ep(grnEvent.ID, and so on)
 	ep1 := grnGcalEvent.ExtendedProperties
 	if ep1 == nil {
 		return false, "ExtendedProperty1: nil"
 	}
-	epValue1, found := ep1.Private[gcalEPKeyGaroonEventID]
-	if !found {
-		epValue1 = ""
-	}
 	ep2 := gcalEvent.ExtendedProperties
 	if ep2 == nil {
 		return false, "ExtendedProperty2: nil"
 	}
-	epValue2, found := ep2.Private[gcalEPKeyGaroonEventID]
-	if !found {
-		epValue2 = ""
-	}
-	if epValue1 != epValue2 {
-		return false, fmt.Sprintf("ExtendedProperty: %v <=> %v", epValue1, epValue2)
+	for _, k := range gcalEPOwnedKeys {
+		epValue1, found := ep1.Private[k]
+		if !found {
+			epValue1 = ""
+		}
+		epValue2, found := ep2.Private[k]
+		if !found {
+			epValue2 = ""
+		}
+		if epValue1 != epValue2 {
+			return false, fmt.Sprintf("ExtendedProperty(%v): %v <=> %v", k, epValue1, epValue2)
+		}
 	}
 
 	// Summary
@@ -198,6 +432,54 @@ func isEqualGcalEvent(grnGcalEvent, gcalEvent *calendar.Event) (bool, string) {
 		return false, fmt.Sprintf("Description: %v <=> %v", grnGcalEvent.Description, gcalEvent.Description)
 	}
 
+	// Visibility ("" is "default")
+	visibility1, visibility2 := grnGcalEvent.Visibility, gcalEvent.Visibility
+	if visibility1 == "" {
+		visibility1 = "default"
+	}
+	if visibility2 == "" {
+		visibility2 = "default"
+	}
+	if visibility1 != visibility2 {
+		return false, fmt.Sprintf("Visibility: %v <=> %v", visibility1, visibility2)
+	}
+
+	// Transparency ("" is "opaque")
+	transparency1, transparency2 := grnGcalEvent.Transparency, gcalEvent.Transparency
+	if transparency1 == "" {
+		transparency1 = "opaque"
+	}
+	if transparency2 == "" {
+		transparency2 = "opaque"
+	}
+	if transparency1 != transparency2 {
+		return false, fmt.Sprintf("Transparency: %v <=> %v", transparency1, transparency2)
+	}
+
+	// Reminders (nil is "useDefault")
+	reminders1, reminders2 := formatGcalReminders(grnGcalEvent.Reminders), formatGcalReminders(gcalEvent.Reminders)
+	if reminders1 != reminders2 {
+		return false, fmt.Sprintf("Reminders: %v <=> %v", reminders1, reminders2)
+	}
+
+	// Attachments
+	attachments1, attachments2 := formatGcalAttachments(grnGcalEvent.Attachments), formatGcalAttachments(gcalEvent.Attachments)
+	if attachments1 != attachments2 {
+		return false, fmt.Sprintf("Attachments: %v <=> %v", attachments1, attachments2)
+	}
+
+	// Status ("" is "confirmed")
+	status1, status2 := grnGcalEvent.Status, gcalEvent.Status
+	if status1 == "" {
+		status1 = "confirmed"
+	}
+	if status2 == "" {
+		status2 = "confirmed"
+	}
+	if status1 != status2 {
+		return false, fmt.Sprintf("Status: %v <=> %v", status1, status2)
+	}
+
 	// compare recurring or not
 	grnGcalEventRecurring := (len(grnGcalEvent.Recurrence) > 0)
 	gcalEventRecurring := (len(gcalEvent.Recurrence) > 0)
@@ -227,10 +509,10 @@ func isEqualGcalEvent(grnGcalEvent, gcalEvent *calendar.Event) (bool, string) {
 		return false, fmt.Sprintf("Time span: failed to compare Gcal (start=%s, end=%s)", gcalstartDT, gcalendDT)
 	}
 	// compare
-	if startDT != gcalstartDT {
+	if !isSameGcalTime(startDT, gcalstartDT) {
 		return false, fmt.Sprintf("Start: %v <=> %v", startDT, gcalstartDT)
 	}
-	if endDT != gcalendDT {
+	if !isSameGcalTime(endDT, gcalendDT) {
 		return false, fmt.Sprintf("End: %s <=> %s", endDT, gcalendDT)
 	}
 
@@ -267,7 +549,7 @@ func eventsAreEqual(grnEvent *GaroonEvent, gcalEvent *calendar.Event) (bool, str
 	}
 
 	// compare recurring or not
-	grnRecurring := (grnEvent.EventType == "repeat")
+	grnRecurring := (grnEvent.EventType == GaroonEventTypeRepeat)
 	gcalRecurring := (len(gcalEvent.Recurrence) > 0)
 	if grnRecurring != gcalRecurring {
 		return false, fmt.Sprintf("Recurring: Garoon %v <=> Gcal %v", grnRecurring, gcalRecurring)
@@ -319,7 +601,7 @@ func eventsAreEqual(grnEvent *GaroonEvent, gcalEvent *calendar.Event) (bool, str
 }
 
 func getGcalTimeSpan(gcalEvent *calendar.Event) (string, string, error) {
-	if gcalEvent == nil || gcalEvent.Start == nil {
+	if gcalEvent == nil || gcalEvent.Start == nil || gcalEvent.End == nil {
 		return "", "", errors.New("start, end is null")
 	}
 
@@ -334,6 +616,23 @@ func getGcalTimeSpan(gcalEvent *calendar.Event) (string, string, error) {
 	return gcalstartDT, gcalendDT, nil
 }
 
+// dates, or datetimes at the same instant in any offsets
+func isSameGcalTime(dt1, dt2 string) bool {
+	if dt1 == dt2 {
+		return true
+	}
+
+	t1, err := time.Parse(time.RFC3339, dt1)
+	if err != nil {
+		return false
+	}
+	t2, err := time.Parse(time.RFC3339, dt2)
+	if err != nil {
+		return false
+	}
+	return t1.Equal(t2)
+}
+
 // start, end, error
 func getGrnTimeSpan(grnEvent *GaroonEvent) (string, string, error) {
 	if grnEvent == nil {
@@ -418,17 +717,217 @@ func convertGrnDateTimeIntoGcalDateTime(grnDT, grnTZ string) (string, error) {
 	return dt.In(loc).Format(time.RFC3339), nil
 }
 
-func isMemberOfGrnEvent(userID string, grnEvent *GaroonEvent) bool {
-	if grnEvent == nil {
+// whether an event is left out of Gcal
+func isSkippedSourceEvent(srcEvent *SourceEvent, syncConfig *SyncConfig) bool {
+	if strings.HasPrefix(srcEvent.Title, "*") {
+		return true
+	}
+
+	if srcEvent.Private && syncConfig.PrivateEvent == PrivateEventSkip {
+		return true
+	}
+
+	return false
+}
+
+// whether candidates of Gcal and an event correspond
+func isCandidateOfSourceEvent(gcalEP map[string]string, srcEvent *SourceEvent) bool {
+	candidate, found := gcalEP[gcalEPKeyGaroonCandidate]
+	if srcEvent.Kind != SourceEventTentative {
+		return !found
+	}
+	if !found {
+		return false
+	}
+
+	i, err := strconv.Atoi(candidate)
+	if err != nil {
 		return false
 	}
+	return 0 <= i && i < len(srcEvent.Spans)
+}
+
+// whether a Garoon event is an all-day event: a banner, "終日" (allday) or dates
+func isAllDayGrnEvent(grnEvent *GaroonEvent) bool {
+	return grnEvent.EventType == GaroonEventTypeBanner || grnEvent.AllDay || len(grnEvent.Date) > 0
+}
 
-	for _, user := range grnEvent.Members {
-		if userID == user.ID {
-			return true
+// whether an event matches a rule
+func matchesEventRule(rule *EventRule, srcEvent *SourceEvent) bool {
+	if rule.Plan != "" && rule.Plan != srcEvent.Category {
+		return false
+	}
+
+	if rule.AllDay != nil && *rule.AllDay != srcEvent.AllDay {
+		return false
+	}
+
+	if rule.Title != "" {
+		matched, err := regexp.MatchString(rule.Title, srcEvent.Title)
+		if err != nil || !matched {
+			return false
 		}
 	}
-	return false
+
+	return true
+}
+
+// nil if no rules match
+func convertIntoGcalReminders(rules []ReminderRule, srcEvent *SourceEvent) *calendar.EventReminders {
+	for i := range rules {
+		if !matchesEventRule(&rules[i].EventRule, srcEvent) {
+			continue
+		}
+
+		reminders := &calendar.EventReminders{
+			UseDefault:      rules[i].UseDefault,
+			Overrides:       []*calendar.EventReminder{},
+			ForceSendFields: []string{"UseDefault", "Overrides"},
+		}
+		if !rules[i].UseDefault {
+			for _, o := range rules[i].Overrides {
+				reminders.Overrides = append(reminders.Overrides, &calendar.EventReminder{
+					Method:          o.Method,
+					Minutes:         o.Minutes,
+					ForceSendFields: []string{"Minutes"},
+				})
+			}
+		}
+		return reminders
+	}
+
+	return nil
+}
+
+// for comparison
+func formatGcalReminders(reminders *calendar.EventReminders) string {
+	if reminders == nil || reminders.UseDefault {
+		return "default"
+	}
+
+	overrides := make([]string, 0, len(reminders.Overrides))
+	for _, o := range reminders.Overrides {
+		overrides = append(overrides, fmt.Sprintf("%s:%d", o.Method, o.Minutes))
+	}
+	sort.Strings(overrides)
+	return "[" + strings.Join(overrides, ",") + "]"
+}
+
+// a description with the newest follow-ups appended
+func formatAsGcalDescription(srcEvent *SourceEvent, syncConfig *SyncConfig) string {
+	var sb strings.Builder
+	sb.WriteString(srcEvent.Description)
+
+	// Gcal attachments are Drive files only; others are listed in the description
+	var listed []*SourceAttachment
+	for _, f := range srcEvent.Attachments {
+		if syncConfig.Attachments == AttachmentsDescription ||
+			syncConfig.Attachments == AttachmentsGcal && !isGcalAttachable(f) {
+			listed = append(listed, f)
+		}
+	}
+	if len(listed) > 0 {
+		if sb.Len() > 0 {
+			sb.WriteString("\n\n")
+		}
+		sb.WriteString(gcalAttachmentsDelimiter)
+		for _, f := range listed {
+			sb.WriteString(fmt.Sprintf("\n%s (%s)\n%s", f.Name, formatFileSize(f.Size), f.URL))
+		}
+	}
+
+	follows := syncConfig.Follows
+	if follows <= 0 || len(srcEvent.Comments) == 0 {
+		return sb.String()
+	}
+
+	sorted := make([]*SourceComment, len(srcEvent.Comments))
+	copy(sorted, srcEvent.Comments)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Date.After(sorted[j].Date)
+	})
+	if len(sorted) > follows {
+		sorted = sorted[:follows]
+	}
+
+	loc, err := time.LoadLocation(srcEvent.TimeZone)
+	if err != nil {
+		loc = time.Local
+	}
+
+	if sb.Len() > 0 {
+		sb.WriteString("\n\n")
+	}
+	sb.WriteString(gcalFollowsDelimiter)
+	for _, c := range sorted {
+		date := c.RawDate
+		if !c.Date.IsZero() {
+			date = c.Date.In(loc).Format("2006-01-02 15:04")
+		}
+		sb.WriteString(fmt.Sprintf("\n\n%s %s\n%s", date, c.Author, c.Text))
+	}
+
+	return sb.String()
+}
+
+// the reverse of formatAsGcalDescription
+func trimGcalDescriptionSections(description string) string {
+	for _, delim := range []string{gcalAttachmentsDelimiter, gcalFollowsDelimiter} {
+		if i := strings.Index(description, delim); i >= 0 {
+			description = strings.TrimRight(description[:i], "\n")
+		}
+	}
+	return description
+}
+
+// such as "12.3 KB"
+func formatFileSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	value, exp := float64(size)/unit, 0
+	for value >= unit && exp < 3 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", value, "KMGT"[exp])
+}
+
+// whether Gcal accepts an attachment as it is.
+// Gcal attachments must be Google Drive files, and Garoon files are not.
+func isGcalAttachable(f *SourceAttachment) bool {
+	u, err := url.Parse(f.URL)
+	if err != nil || u.Scheme != "https" {
+		return false
+	}
+	return u.Host == "drive.google.com" || u.Host == "docs.google.com"
+}
+
+// Gcal attachments linking to attachments of an event
+func convertIntoGcalAttachments(srcEvent *SourceEvent) []*calendar.EventAttachment {
+	var attachments []*calendar.EventAttachment
+	for _, f := range srcEvent.Attachments {
+		if !isGcalAttachable(f) {
+			continue
+		}
+		attachments = append(attachments, &calendar.EventAttachment{
+			FileUrl:  f.URL,
+			Title:    f.Name,
+			MimeType: f.MimeType,
+		})
+	}
+	return attachments
+}
+
+// such as "a.pdf=https://...,b.txt=https://..."
+func formatGcalAttachments(attachments []*calendar.EventAttachment) string {
+	var s []string
+	for _, a := range attachments {
+		s = append(s, a.Title+"="+a.FileUrl)
+	}
+	return strings.Join(s, ",")
 }
 
 func formatAsGcalSummary(menu, title string) string {
@@ -461,6 +960,9 @@ func convertGrnRecurrenceIntoGcalRecurrence(grnEvent *GaroonEvent) ([]string, *c
 	end := grncond.StartDate // not grncond.EndDate, as a unit event
 	if grncond.EndTime != "" {
 		end += "T" + grncond.EndTime + "Z"
+	} else if grncond.StartTime != "" {
+		// start_only
+		end += "T" + grncond.StartTime + "Z"
 	}
 	until := strings.Replace(grncond.EndDate, "-", "", -1)
 
@@ -559,42 +1061,246 @@ func convertGrnRecurrenceIntoGcalRecurrence(grnEvent *GaroonEvent) ([]string, *c
 		&calendar.EventDateTime{DateTime: end, TimeZone: endTZ}
 }
 
-// convert a Garoon event into a Gcal event
+// convert an event into Gcal events.
+// a tentative event becomes a tentative Gcal event per candidate.
+func convertIntoGcalEvents(srcEvent *SourceEvent, syncConfig *SyncConfig) ([]calendar.Event, error) {
+	if srcEvent.Kind != SourceEventTentative {
+		span := SourceSpan{}
+		if len(srcEvent.Spans) > 0 {
+			span = srcEvent.Spans[0]
+		}
+		gcalEvent, err := convertIntoGcalEvent(srcEvent, span, syncConfig)
+		if err != nil {
+			return nil, err
+		}
+		return []calendar.Event{gcalEvent}, nil
+	}
+
+	gcalEvents := make([]calendar.Event, 0, len(srcEvent.Spans))
+	for i := range srcEvent.Spans {
+		gcalEvent, err := convertIntoGcalEvent(srcEvent, srcEvent.Spans[i], syncConfig)
+		if err != nil {
+			return nil, err
+		}
+		gcalEvent.Status = "tentative"
+		gcalEvent.ExtendedProperties.Private[gcalEPKeyGaroonCandidate] = strconv.Itoa(i)
+
+		gcalEvents = append(gcalEvents, gcalEvent)
+	}
+	return gcalEvents, nil
+}
+
+// convert a span of an event into a Gcal event
 // without extened properties.
-func convertIntoGcalEvent(grnEvent *GaroonEvent) (calendar.Event, error) {
+func convertIntoGcalEvent(srcEvent *SourceEvent, span SourceSpan, syncConfig *SyncConfig) (calendar.Event, error) {
 	ep := calendar.EventExtendedProperties{}
 	ep.Private = make(map[string]string)
-	ep.Private[gcalEPKeyGaroonEventID] = grnEvent.ID
+	ep.Private[gcalEPKeyGaroonEventID] = srcEvent.ID
 	ep.Shared = make(map[string]string)
 
 	gcalEvent := calendar.Event{
-		Summary:            formatAsGcalSummary(grnEvent.Plan, grnEvent.Detail),
-		Description:        grnEvent.Description,
+		Summary:            formatAsGcalSummary(srcEvent.Category, srcEvent.Title),
+		Description:        formatAsGcalDescription(srcEvent, syncConfig),
 		ExtendedProperties: &ep,
 	}
 
-	startDT, endDT, _ := getGrnTimeSpan(grnEvent)
-	if grnEvent.Repeat != nil {
-		r, s, e := convertGrnRecurrenceIntoGcalRecurrence(grnEvent)
-		if len(r) > 0 {
-			gcalEvent.Recurrence = r
+	if syncConfig.Attachments == AttachmentsGcal {
+		gcalEvent.Attachments = convertIntoGcalAttachments(srcEvent)
+	}
+
+	switch {
+	case span.Start == "":
+		// no date or datetime
+
+	case srcEvent.Kind == SourceEventRecurring:
+		if len(srcEvent.Recurrence) > 0 {
+			gcalEvent.Recurrence = srcEvent.Recurrence
 		}
-		gcalEvent.Start = s
-		gcalEvent.End = e
-	} else {
-		if len(grnEvent.Datetime) > 0 {
-			gcalEvent.Start = &calendar.EventDateTime{DateTime: startDT}
-			gcalEvent.End = &calendar.EventDateTime{DateTime: endDT}
+		gcalEvent.Start = &calendar.EventDateTime{DateTime: span.Start, TimeZone: srcEvent.TimeZone}
+		gcalEvent.End = &calendar.EventDateTime{DateTime: span.End, TimeZone: srcEvent.EndTimeZone}
+
+	case span.Date:
+		gcalEvent.Start = &calendar.EventDateTime{Date: span.Start}
+		gcalEvent.End = &calendar.EventDateTime{Date: span.End}
+
+	default:
+		gcalEvent.Start = &calendar.EventDateTime{DateTime: span.Start}
+		gcalEvent.End = &calendar.EventDateTime{DateTime: span.End}
+	}
+
+	if srcEvent.StartOnly && gcalEvent.Start != nil && gcalEvent.Start.DateTime != "" {
+		ep.Private[gcalEPKeyGaroonStartOnly] = "true"
+
+		startDT, err := time.Parse(time.RFC3339, gcalEvent.Start.DateTime)
+		if err != nil {
+			return gcalEvent, fmt.Errorf("Failed to parse Gcal DateTime(%s): %v", gcalEvent.Start.DateTime, err)
 		}
-		if len(grnEvent.Date) > 0 {
-			gcalEvent.Start = &calendar.EventDateTime{Date: startDT}
-			gcalEvent.End = &calendar.EventDateTime{Date: endDT}
+
+		endDT := startDT
+		if syncConfig.StartOnlyMode == StartOnlyModePoint {
+			gcalEvent.Transparency = "transparent"
+		} else {
+			endDT = startDT.Add(syncConfig.GetStartOnlyDuration())
+		}
+		gcalEvent.End = &calendar.EventDateTime{DateTime: endDT.Format(time.RFC3339), TimeZone: gcalEvent.Start.TimeZone}
+	}
+
+	gcalEvent.Reminders = convertIntoGcalReminders(syncConfig.Reminders, srcEvent)
+
+	for i := range syncConfig.Transparency {
+		if matchesEventRule(&syncConfig.Transparency[i].EventRule, srcEvent) {
+			gcalEvent.Transparency = syncConfig.Transparency[i].Transparency
+			break
+		}
+	}
+
+	if srcEvent.Private {
+		switch syncConfig.PrivateEvent {
+		case PrivateEventPrivate:
+			gcalEvent.Visibility = "private"
+		case PrivateEventBusy:
+			gcalEvent.Summary = privateEventBusySummary
+			gcalEvent.Description = ""
+			gcalEvent.Attachments = nil
 		}
 	}
 
 	return gcalEvent, nil
 }
 
+// start date, end date (exclusive), error
+// a banner event becomes a (multi-day) all-day Gcal event.
+func getGrnBannerDateSpan(grnEvent *GaroonEvent) (string, string, error) {
+	var startDate, endDate time.Time
+
+	if len(grnEvent.Date) > 0 {
+		start, end := grnEvent.Date[0].Start, grnEvent.Date[0].End
+		if len(end) == 0 {
+			end = start
+		}
+
+		var err error
+		startDate, err = time.Parse("2006-01-02", start)
+		if err != nil {
+			return "", "", fmt.Errorf("Failed to parse Garoon Date(%s): %v", start, err)
+		}
+		endDate, err = time.Parse("2006-01-02", end)
+		if err != nil {
+			return "", "", fmt.Errorf("Failed to parse Garoon Date(%s): %v", end, err)
+		}
+		endDate = endDate.AddDate(0, 0, 1)
+
+	} else if len(grnEvent.Datetime) > 0 {
+		start, end := grnEvent.Datetime[0].Start, grnEvent.Datetime[0].End
+		if len(end) == 0 {
+			end = start
+		}
+
+		loc, err := time.LoadLocation(grnEvent.TimeZone)
+		if err != nil {
+			return "", "", err
+		}
+		startDT, err := time.Parse(time.RFC3339, start)
+		if err != nil {
+			return "", "", err
+		}
+		endDT, err := time.Parse(time.RFC3339, end)
+		if err != nil {
+			return "", "", err
+		}
+		startDT, endDT = startDT.In(loc), endDT.In(loc)
+
+		startDate = time.Date(startDT.Year(), startDT.Month(), startDT.Day(), 0, 0, 0, 0, time.UTC)
+		endDate = time.Date(endDT.Year(), endDT.Month(), endDT.Day(), 0, 0, 0, 0, time.UTC)
+		// [stt, end] => [stt, end)
+		// unless the banner ends at 00:00
+		if !endDT.Equal(time.Date(endDT.Year(), endDT.Month(), endDT.Day(), 0, 0, 0, 0, loc)) || !endDate.After(startDate) {
+			endDate = endDate.AddDate(0, 0, 1)
+		}
+
+	} else {
+		return "", "", errors.New("no date or datetime in a banner event")
+	}
+
+	return startDate.Format("2006-01-02"), endDate.Format("2006-01-02"), nil
+}
+
+// private extended property expressions to identify a Gcal event
+func gcalEventKeys(gcalEvent *calendar.Event) []string {
+	keys := make([]string, 0, 2)
+
+	ep := gcalEvent.ExtendedProperties
+	if ep == nil {
+		return keys
+	}
+	for _, k := range []string{gcalEPKeyGaroonEventID, gcalEPKeyGaroonCandidate} {
+		if v, found := ep.Private[k]; found {
+			keys = append(keys, k+"="+v)
+		}
+	}
+	return keys
+}
+
+// the first event identified by keys from gcalEventKeys.
+// a sync key absent from keys must be absent from the event,
+// so that a leftover candidate is not taken for the confirmed event.
+func selectGcalEventByKeys(events []*calendar.Event, epexprs ...string) *calendar.Event {
+	want := make(map[string]string)
+	for _, expr := range epexprs {
+		kv := strings.SplitN(expr, "=", 2)
+		if len(kv) != 2 {
+			return nil
+		}
+		want[kv[0]] = kv[1]
+	}
+
+	for _, ev := range events {
+		var ep map[string]string
+		if ev.ExtendedProperties != nil {
+			ep = ev.ExtendedProperties.Private
+		}
+
+		matched := true
+		for _, k := range []string{gcalEPKeyGaroonEventID, gcalEPKeyGaroonCandidate} {
+			v, found := ep[k]
+			wantV, wanted := want[k]
+			if found != wanted || v != wantV {
+				matched = false
+				break
+			}
+		}
+		for k, wantV := range want {
+			if v, found := ep[k]; !found || v != wantV {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return ev
+		}
+	}
+	return nil
+}
+
+// overwrite extended properties grn2gcal owns.
+// others (added by a user or another app) are kept.
+func mergeExtendedProperties(dst, src *calendar.Event) {
+	if dst.ExtendedProperties == nil {
+		dst.ExtendedProperties = &calendar.EventExtendedProperties{}
+	}
+	if dst.ExtendedProperties.Private == nil {
+		dst.ExtendedProperties.Private = make(map[string]string)
+	}
+
+	for _, k := range gcalEPOwnedKeys {
+		if v, found := src.ExtendedProperties.Private[k]; found {
+			dst.ExtendedProperties.Private[k] = v
+		} else {
+			delete(dst.ExtendedProperties.Private, k)
+		}
+	}
+}
+
 func homeDirPath() string {
 	var path string
 
@@ -607,73 +1313,66 @@ func homeDirPath() string {
 	return path
 }
 
-func syncGrn2Gcal(grnEvent *GaroonEvent, gcal *calendar.Service, gcalCalendarID string, wg *sync.WaitGroup, updm *sync.Mutex) {
+func syncGrn2Gcal(ctx context.Context, srcEvent *SourceEvent, target CalendarTarget, source EventSource, syncConfig *SyncConfig, wg *sync.WaitGroup, updm *sync.Mutex) {
 	wg.Add(1)
 
-	startDT, endDT, err := getGrnTimeSpan(grnEvent)
+	grnGcalEvents, err := convertIntoGcalEvents(srcEvent, syncConfig)
 	if err != nil {
-		log.Printf("Failed to get date/datetime values from a Garoon event: %v\n", err)
+		log.Printf("Failed to convert Garoon event into Gcal event: %v\n", err)
 		wg.Done()
 		return
 	}
-	if grnEvent.Repeat != nil {
-		r, s, e := convertGrnRecurrenceIntoGcalRecurrence(grnEvent)
-		if r == nil && s == nil && e == nil {
-			log.Printf("Failed to convert recurrence (%v %v) of %s", grnEvent.Repeat, grnEvent.Repeat.Condition, formatAsGcalSummary(grnEvent.Plan, grnEvent.Detail), grnEvent.ID)
-			wg.Done()
-			return
-		}
-		log.Printf("Garoon Event: %v - %v REPEAT %v ... %v %v\n", s.DateTime, e.DateTime, r, formatAsGcalSummary(grnEvent.Plan, grnEvent.Detail), grnEvent.ID)
+
+	for i := range grnGcalEvents {
+		syncGrnGcalEvent(ctx, srcEvent, &grnGcalEvents[i], target, source, syncConfig, updm)
+	}
+
+	wg.Done()
+}
+
+// insert or update a Gcal event converted from a Garoon event
+func syncGrnGcalEvent(ctx context.Context, srcEvent *SourceEvent, grnGcalEvent *calendar.Event, target CalendarTarget, source EventSource, syncConfig *SyncConfig, updm *sync.Mutex) {
+	startDT, endDT, err := getGcalTimeSpan(grnGcalEvent)
+	if err != nil {
+		log.Printf("Failed to get date/datetime values from a Garoon event: %v\n", err)
+		return
+	}
+	grnEventID := grnGcalEvent.ExtendedProperties.Private[gcalEPKeyGaroonEventID]
+	if len(grnGcalEvent.Recurrence) > 0 {
+		log.Printf("Garoon Event: %v - %v REPEAT %v ... %v %v\n", startDT, endDT, grnGcalEvent.Recurrence, grnGcalEvent.Summary, grnEventID)
+	} else if grnGcalEvent.Status == "tentative" {
+		log.Printf("Garoon Event: %v - %v TEMPORARY ... %v %v\n", startDT, endDT, grnGcalEvent.Summary, grnEventID)
 	} else {
-		log.Printf("Garoon Event: %v - %v ... %v %v\n", startDT, endDT, formatAsGcalSummary(grnEvent.Plan, grnEvent.Detail), grnEvent.ID)
+		log.Printf("Garoon Event: %v - %v ... %v %v\n", startDT, endDT, grnGcalEvent.Summary, grnEventID)
 	}
 
 	// Identify Gcal events and perform insert/update/delete
 
-	gcalFetchedEvent, _ := FetchEventByExtendedProperty(gcal, gcalCalendarID, gcalEPKeyGaroonEventID+"="+grnEvent.ID)
+	gcalFetchedEvent, _ := target.FindBySourceID(ctx, gcalEventKeys(grnGcalEvent)...)
 	if gcalFetchedEvent == nil {
 		log.Print("  => New")
 
-		// construct a Gcal Event
+		beeep.Notify("Add Gcal Event", fmt.Sprintf("%v - %v\n%v\n", startDT, endDT, grnGcalEvent.Summary), "" /*"assets/information.png"*/)
 
-		newEvent, err := convertIntoGcalEvent(grnEvent)
-		if err != nil {
-			log.Printf("Failed to convert Garoon event into Gcal event: %v\n", err)
-			wg.Done()
-			return
-		}
-		//log.Printf("☆grnEvent=%+v\n", grnEvent)
-		//log.Printf("☆grnEvent.Repeat.Condition=%+v\n", grnEvent.Repeat.Condition)
-		//log.Printf("☆grnEvent.Repeat.Exclusive=%+v\n", grnEvent.Repeat.Exclusive)
-		//for _, m := range grnEvent.Members {
-		//	log.Printf("☆grnEvent.Member=%+v\n", m)
-		//}
-		//log.Printf("★newEvent=%+v\n", newEvent)
-		//log.Printf("★newEvent.Start=%+v\n", newEvent.Start)
-		//log.Printf("★newEvent.End=%+v\n", newEvent.End)
-
-		beeep.Notify("Add Gcal Event", fmt.Sprintf("%v - %v\n%v\n", startDT, endDT, formatAsGcalSummary(grnEvent.Plan, grnEvent.Detail)), "" /*"assets/information.png"*/)
+		grnGcalEvent.ExtendedProperties.Private[gcalEPKeySyncHash] = gcalContentHash(grnGcalEvent)
 
 		/*v*/
 		updm.Lock()
-		_, err = gcal.Events.Insert(gcalCalendarID, &newEvent).Do()
+		err = target.Insert(ctx, grnGcalEvent)
 		if err != nil {
 			log.Printf("    An error occurred inserting a Gcal event: %v\n", err)
 			//continue
 			updm.Unlock()
-			wg.Done()
 			return
 		}
 		updm.Unlock()
 		//log.Printf("    Calendar ID %q event: %v(%v) %v: %q\n", gcalCalendarID, v.ID, v.Kind, v.Updated, v.Summary)
 	} else {
-		grnGcalEvent, err := convertIntoGcalEvent(grnEvent)
-		if err != nil {
-		}
-		eq, cause := isEqualGcalEvent(&grnGcalEvent, gcalFetchedEvent)
-		//eq, cause := eventsAreEqual(grnEvent, gcalFetchedEvent)
+		eq, cause := isEqualGcalEvent(grnGcalEvent, gcalFetchedEvent)
 		if eq {
 			//log.Println("  => No Changes")
+		} else if isConflictedGcalEvent(gcalFetchedEvent) && !resolveGcalConflict(ctx, srcEvent, gcalFetchedEvent, target, source, syncConfig, updm) {
+			// resolved in Gcal's favor, or skipped
 		} else {
 			log.Printf("  => Change (%v)\n", cause)
 
@@ -681,31 +1380,42 @@ func syncGrn2Gcal(grnEvent *GaroonEvent, gcal *calendar.Service, gcalCalendarID
 
 			gcalFetchedEvent.Summary = grnGcalEvent.Summary
 			gcalFetchedEvent.Description = grnGcalEvent.Description
+			gcalFetchedEvent.Visibility = grnGcalEvent.Visibility
+			gcalFetchedEvent.Status = grnGcalEvent.Status
+			gcalFetchedEvent.Transparency = grnGcalEvent.Transparency
+			gcalFetchedEvent.Reminders = grnGcalEvent.Reminders
+			if gcalFetchedEvent.Reminders == nil {
+				gcalFetchedEvent.Reminders = &calendar.EventReminders{UseDefault: true}
+			}
 
+			gcalFetchedEvent.Attachments = grnGcalEvent.Attachments
+			if gcalFetchedEvent.Attachments == nil {
+				gcalFetchedEvent.ForceSendFields = append(gcalFetchedEvent.ForceSendFields, "Attachments")
+			}
 			gcalFetchedEvent.Recurrence = grnGcalEvent.Recurrence
 			gcalFetchedEvent.Start = grnGcalEvent.Start
 			gcalFetchedEvent.End = grnGcalEvent.End
 
-			beeep.Notify("Update Gcal Event", fmt.Sprintf("%v - %v\n%v\n", startDT, endDT, formatAsGcalSummary(grnEvent.Plan, grnEvent.Detail)), "" /*"assets/information.png"*/)
+			mergeExtendedProperties(gcalFetchedEvent, grnGcalEvent)
+			gcalFetchedEvent.ExtendedProperties.Private[gcalEPKeySyncHash] = gcalContentHash(gcalFetchedEvent)
+
+			beeep.Notify("Update Gcal Event", fmt.Sprintf("%v - %v\n%v\n", startDT, endDT, grnGcalEvent.Summary), "" /*"assets/information.png"*/)
 
 			/*v*/
 			updm.Lock()
-			_, err := gcal.Events.Update(gcalCalendarID, gcalFetchedEvent.Id, gcalFetchedEvent).Do()
+			err := target.Update(ctx, gcalFetchedEvent)
 			if err != nil {
 				log.Printf("    An error occurred updating a Gcal event: %v\n", err)
 				//continue
 				updm.Unlock()
-				wg.Done()
 				return
 			}
 			updm.Unlock()
 		}
 	}
-
-	wg.Done()
 }
 
-func syncGcal2Grn(gcalEvent *calendar.Event, gcal *calendar.Service, gcalCalendarID string, grn *Service, targetUser UtilGetLoginUserIDResult, wg *sync.WaitGroup, updm *sync.Mutex) {
+func syncGcal2Grn(ctx context.Context, gcalEvent *calendar.Event, target CalendarTarget, source EventSource, userID string, syncConfig *SyncConfig, wg *sync.WaitGroup, updm *sync.Mutex) {
 	wg.Add(1)
 
 	if gcalEvent == nil || gcalEvent.Start == nil {
@@ -738,16 +1448,17 @@ func syncGcal2Grn(gcalEvent *calendar.Event, gcal *calendar.Service, gcalCalenda
 
 	// Gcal event to be deleted
 
-	grnEventList, err := grn.ScheduleGetEventsByID(grnEventID)
+	srcEvent, err := source.GetEvent(ctx, grnEventID)
 	if err != nil {
 		log.Printf("Failed to fetch a Garoon event(ID=%v): %v\n", grnEventID, err)
 		wg.Done()
 		return
 	}
 
-	if len(grnEventList.Events) == 0 ||
-		!isMemberOfGrnEvent(targetUser.UserID, grnEventList.Events[0]) ||
-		strings.HasPrefix(grnEventList.Events[0].Detail, "*") {
+	if srcEvent == nil ||
+		!isAttendeeOfSourceEvent(userID, srcEvent) ||
+		isSkippedSourceEvent(srcEvent, syncConfig) ||
+		!isCandidateOfSourceEvent(ep.Private, srcEvent) {
 		// Garoon origin event
 
 		log.Print("  => Delete")
@@ -755,7 +1466,7 @@ func syncGcal2Grn(gcalEvent *calendar.Event, gcal *calendar.Service, gcalCalenda
 		beeep.Notify("DELETE Gcal Event", fmt.Sprintf("%s - %s\n%s\n", startDT, endDT, gcalEvent.Summary), "" /*"assets/information.png"*/)
 
 		updm.Lock()
-		err := gcal.Events.Delete(gcalCalendarID, gcalEvent.Id).Do()
+		err := target.Delete(ctx, gcalEvent)
 		if err != nil {
 			log.Printf("    An error occurred deleting a Gcal event: %v\n", err)
 			updm.Unlock()
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// Gcal events converted from a recorded Garoon event
+func convertFixtureIntoGcalEvents(t *testing.T, grnEvent *GaroonEvent, syncConfig *SyncConfig) []calendar.Event {
+	t.Helper()
+
+	srcEvent, err := convertGrnEventIntoSourceEvent(grnEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcalEvents, err := convertIntoGcalEvents(srcEvent, syncConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gcalEvents
+}
+
+func TestConvertTemporaryCandidates(t *testing.T) {
+	var result ScheduleGetEventsResult
+	loadGaroonFixture(t, "ScheduleGetEventsResponse_temporary.xml", &result)
+
+	gcalEvents := convertFixtureIntoGcalEvents(t, result.Events[0], &SyncConfig{})
+	if len(gcalEvents) != 3 {
+		t.Fatalf("len = %d, want a Gcal event per candidate", len(gcalEvents))
+	}
+
+	wantStarts := []string{"2020-06-01T10:00:00+09:00", "2020-06-02T14:00:00+09:00", "2020-06-03T09:30:00+09:00"}
+	for i, ev := range gcalEvents {
+		if ev.Status != "tentative" {
+			t.Errorf("[%d] Status = %q, want tentative", i, ev.Status)
+		}
+		if ev.Start.DateTime != wantStarts[i] {
+			t.Errorf("[%d] Start = %s, want %s", i, ev.Start.DateTime, wantStarts[i])
+		}
+		keys := gcalEventKeys(&ev)
+		if len(keys) != 2 || keys[0] != "garoon_event_id=101" || keys[1] != "garoon_candidate="+strconv.Itoa(i) {
+			t.Errorf("[%d] keys = %v", i, keys)
+		}
+	}
+
+	banner := convertFixtureIntoGcalEvents(t, result.Events[1], &SyncConfig{})
+	if len(banner) != 1 || banner[0].Start.Date != "2020-06-01" || banner[0].End.Date != "2020-06-04" {
+		t.Errorf("banner = %+v - %+v", banner[0].Start, banner[0].End)
+	}
+}
+
+func TestSelectGcalEventByKeys(t *testing.T) {
+	var temporary ScheduleGetEventsResult
+	loadGaroonFixture(t, "ScheduleGetEventsResponse_temporary.xml", &temporary)
+	var confirmed ScheduleGetEventsByIDResult
+	loadGaroonFixture(t, "ScheduleGetEventsByIdResponse_confirmed.xml", &confirmed)
+
+	// candidates synced before, and the event confirmed afterwards
+	candidates := convertFixtureIntoGcalEvents(t, temporary.Events[0], &SyncConfig{})
+	confirmedEvents := convertFixtureIntoGcalEvents(t, confirmed.Events[0], &SyncConfig{})
+	if len(confirmedEvents) != 1 {
+		t.Fatalf("len = %d, want 1", len(confirmedEvents))
+	}
+	confirmedKeys := gcalEventKeys(&confirmedEvents[0])
+
+	// leftover candidates only
+	leftovers := []*calendar.Event{&candidates[0], &candidates[1], &candidates[2]}
+	if found := selectGcalEventByKeys(leftovers, confirmedKeys...); found != nil {
+		t.Errorf("a leftover candidate %v is taken for the confirmed event", found.ExtendedProperties.Private)
+	}
+
+	// leftover candidates and the confirmed event
+	mirrored := confirmedEvents[0]
+	events := append(leftovers, &mirrored)
+	if found := selectGcalEventByKeys(events, confirmedKeys...); found != &mirrored {
+		t.Errorf("found = %v, want the confirmed event", found)
+	}
+
+	// each candidate by its own keys
+	for i := range candidates {
+		if found := selectGcalEventByKeys(events, gcalEventKeys(&candidates[i])...); found != leftovers[i] {
+			t.Errorf("candidate %d: found = %v", i, found)
+		}
+	}
+
+	// other events
+	if found := selectGcalEventByKeys(events, "garoon_event_id=102"); found != nil {
+		t.Errorf("found = %v, want nil", found)
+	}
+}
+
+func TestAllDayRules(t *testing.T) {
+	var result ScheduleGetEventsResult
+	loadGaroonFixture(t, "ScheduleGetEventsResponse_allday.xml", &result)
+
+	allDay, notAllDay := true, false
+	syncConfig := &SyncConfig{
+		Reminders: []ReminderRule{
+			{EventRule: EventRule{AllDay: &allDay}, Overrides: []Reminder{}},
+			{EventRule: EventRule{AllDay: &notAllDay}, Overrides: []Reminder{{Method: "popup", Minutes: 10}}},
+		},
+		Transparency: []TransparencyRule{
+			{EventRule: EventRule{AllDay: &allDay}, Transparency: "transparent"},
+		},
+	}
+
+	// 在宅 (allday="true")
+	wfh := convertFixtureIntoGcalEvents(t, result.Events[0], syncConfig)[0]
+	if wfh.Reminders == nil || wfh.Reminders.UseDefault || len(wfh.Reminders.Overrides) != 0 {
+		t.Errorf("reminders of an all-day event = %+v, want none", wfh.Reminders)
+	}
+	if wfh.Transparency != "transparent" {
+		t.Errorf("transparency of an all-day event = %q, want transparent", wfh.Transparency)
+	}
+	if wfh.Start.Date != "2020-06-05" || wfh.End.Date != "2020-06-06" {
+		t.Errorf("span of an all-day event = %+v - %+v, want 2020-06-05 - 2020-06-06", wfh.Start, wfh.End)
+	}
+
+	// 定例
+	meeting := convertFixtureIntoGcalEvents(t, result.Events[1], syncConfig)[0]
+	if meeting.Reminders == nil || len(meeting.Reminders.Overrides) != 1 || meeting.Reminders.Overrides[0].Minutes != 10 {
+		t.Errorf("reminders of a meeting = %+v, want popup 10m", meeting.Reminders)
+	}
+	if meeting.Transparency != "" {
+		t.Errorf("transparency of a meeting = %q, want default", meeting.Transparency)
+	}
+}
+
+func TestGcalAttachments(t *testing.T) {
+	srcEvent := &SourceEvent{
+		ID:          "301",
+		Title:       "定例",
+		Description: "agenda",
+		Attachments: []*SourceAttachment{
+			{Name: "minutes.pdf", Size: 2048, URL: "https://garoon.example.com/g/grn/schedule/file_download.csp?event=301&fid=1"},
+			{Name: "slides", URL: "https://drive.google.com/open?id=abc"},
+		},
+	}
+
+	gcalEvents, err := convertIntoGcalEvents(srcEvent, &SyncConfig{Attachments: AttachmentsGcal})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := gcalEvents[0]
+
+	if len(ev.Attachments) != 1 || ev.Attachments[0].Title != "slides" {
+		t.Errorf("Attachments = %v, want the Drive file only", formatGcalAttachments(ev.Attachments))
+	}
+	want := "agenda\n\n" + gcalAttachmentsDelimiter + "\nminutes.pdf (2.0 KB)\n" + srcEvent.Attachments[0].URL
+	if ev.Description != want {
+		t.Errorf("Description = %q, want %q", ev.Description, want)
+	}
+}
+
+func TestFormatFollowsWithRawDates(t *testing.T) {
+	var result ScheduleGetEventsByIDResult
+	loadGaroonFixture(t, "ScheduleGetEventsByIdResponse_confirmed.xml", &result)
+	grnEvent := result.Events[0]
+
+	for _, date := range []string{"2020-06-02T01:00:00Z", "2020/06/03 12:00"} {
+		f := &GaroonFollow{Text: "about " + date}
+		f.Creator.Name = "山田 太郎"
+		f.Creator.Date = date
+		grnEvent.Follows = append(grnEvent.Follows, f)
+	}
+
+	srcEvent, err := convertGrnEventIntoSourceEvent(grnEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	description := formatAsGcalDescription(srcEvent, &SyncConfig{Follows: 5})
+
+	for _, want := range []string{
+		"\n\n2020-06-02 10:00 山田 太郎\nabout 2020-06-02T01:00:00Z",
+		"\n\n2020/06/03 12:00 山田 太郎\nabout 2020/06/03 12:00",
+	} {
+		if !strings.Contains(description, want) {
+			t.Errorf("no %q in %q", want, description)
+		}
+	}
+}
@@ -0,0 +1,834 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+/*
+ * memo
+ *  Garoon event => Gcal event(s) (convertIntoGcalEvents) => VEVENT(s)
+ *  so that .ics files look the same as Gcal.
+ */
+
+const (
+	icsProdID     string = "-//shu-go//grn2gcal//EN"
+	icsDateFormat string = "20060102"
+	icsTimeFormat string = "20060102T150405"
+)
+
+// icsCalendar ...
+// an iCalendar (RFC 5545) converted from Garoon events
+type icsCalendar struct {
+	now    time.Time
+	events []*icsEvent
+
+	uids map[string]bool
+	// span of times per TZID, to write VTIMEZONE
+	zones map[string]*icsZoneSpan
+
+	// write X-GRN2GCAL-* properties to read events back
+	extendedProperties bool
+}
+
+type icsEvent struct {
+	uid     string
+	tzid    string
+	event   calendar.Event
+	exdates []string
+}
+
+type icsZoneSpan struct {
+	from, to time.Time
+}
+
+func newICSCalendar(now time.Time) *icsCalendar {
+	return &icsCalendar{
+		now:   now,
+		uids:  make(map[string]bool),
+		zones: make(map[string]*icsZoneSpan),
+	}
+}
+
+// fetchICSEvents ...
+// adds Garoon events of a profile into an icsCalendar
+func fetchICSEvents(ctx context.Context, config *Config, cal *icsCalendar, syncStart, syncEnd time.Time) error {
+	source, err := newEventSource(config)
+	if err != nil {
+		return err
+	}
+
+	userID, err := source.CurrentUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	srcEvents, err := source.ListEvents(ctx, syncStart, syncEnd)
+	if err != nil {
+		return err
+	}
+
+	host := "garoon"
+	if config.Garoon.CSVFile == "" {
+		if u, err := url.Parse(config.Garoon.BaseURL); err == nil && u.Hostname() != "" {
+			host = u.Hostname()
+		}
+	}
+
+	for _, srcEvent := range srcEvents {
+		if !isAttendeeOfSourceEvent(userID, srcEvent) || isSkippedSourceEvent(srcEvent, &config.Sync) {
+			continue
+		}
+
+		if err := cal.Add(srcEvent, &config.Sync, host); err != nil {
+			log.Printf("Failed to convert Garoon event into iCalendar event: %v\n", err)
+		}
+	}
+
+	return nil
+}
+
+// Add ...
+// converts an event into VEVENT(s).
+// an event already added (by UID) is ignored.
+func (c *icsCalendar) Add(srcEvent *SourceEvent, syncConfig *SyncConfig, host string) error {
+	gcalEvents, err := convertIntoGcalEvents(srcEvent, syncConfig)
+	if err != nil {
+		return err
+	}
+
+	for i := range gcalEvents {
+		uid := "garoon-" + srcEvent.ID
+		if candidate, found := gcalEvents[i].ExtendedProperties.Private[gcalEPKeyGaroonCandidate]; found {
+			uid += "-" + candidate
+		}
+		uid += "@" + host
+		if c.uids[uid] {
+			continue
+		}
+		c.uids[uid] = true
+
+		ev := newICSEvent(uid, &gcalEvents[i], srcEvent.TimeZone)
+		if ev == nil {
+			// no date or datetime
+			continue
+		}
+		if srcEvent.Kind == SourceEventRecurring {
+			ev.exdates = c.exdates(srcEvent, ev)
+		}
+		c.addEvent(ev)
+	}
+
+	return nil
+}
+
+// a VEVENT of a Gcal event, or nil without start or end.
+// DATE-TIME values are in tzid (default: UTC).
+func newICSEvent(uid string, gcalEvent *calendar.Event, tzid string) *icsEvent {
+	if gcalEvent.Start == nil || gcalEvent.End == nil {
+		return nil
+	}
+
+	ev := &icsEvent{
+		uid:   uid,
+		event: *gcalEvent,
+	}
+	if gcalEvent.Start.DateTime != "" {
+		ev.tzid = tzid
+		if _, err := time.LoadLocation(ev.tzid); ev.tzid == "" || err != nil {
+			ev.tzid = "UTC"
+		}
+	}
+	return ev
+}
+
+func (c *icsCalendar) addEvent(ev *icsEvent) {
+	c.events = append(c.events, ev)
+	c.extendZone(ev)
+}
+
+// EXDATE values of a recurring event
+func (c *icsCalendar) exdates(srcEvent *SourceEvent, ev *icsEvent) []string {
+	loc := time.UTC
+	var start time.Time
+	if ev.tzid != "" {
+		loc, _ = time.LoadLocation(ev.tzid)
+		start, _ = time.Parse(time.RFC3339, ev.event.Start.DateTime)
+		start = start.In(loc)
+	}
+
+	var exdates []string
+	for _, ex := range srcEvent.Exclusions {
+		exDT := ex.In(loc)
+
+		if ev.tzid == "" {
+			exdates = append(exdates, exDT.Format(icsDateFormat))
+			continue
+		}
+		exdate := time.Date(exDT.Year(), exDT.Month(), exDT.Day(), start.Hour(), start.Minute(), start.Second(), 0, loc)
+		exdates = append(exdates, exdate.Format(icsTimeFormat))
+	}
+	return exdates
+}
+
+// keep times of an event to cover them with VTIMEZONE
+func (c *icsCalendar) extendZone(ev *icsEvent) {
+	if ev.tzid == "" || ev.tzid == "UTC" {
+		return
+	}
+
+	times := []string{ev.event.Start.DateTime, ev.event.End.DateTime}
+	var until time.Time
+	for _, r := range ev.event.Recurrence {
+		if u, found := rruleUntil(r); found {
+			until, _ = time.Parse(icsDateFormat, u)
+		}
+	}
+
+	span, found := c.zones[ev.tzid]
+	for _, s := range times {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			continue
+		}
+		if !found {
+			span = &icsZoneSpan{from: t, to: t}
+			c.zones[ev.tzid] = span
+			found = true
+		}
+		if t.Before(span.from) {
+			span.from = t
+		}
+		if t.After(span.to) {
+			span.to = t
+		}
+	}
+	if found && until.After(span.to) {
+		span.to = until.AddDate(0, 0, 1)
+	}
+}
+
+// WriteTo ...
+// writes a VCALENDAR
+func (c *icsCalendar) WriteTo(w io.Writer) (int64, error) {
+	iw := &icsWriter{w: bufio.NewWriter(w)}
+
+	iw.line("BEGIN:VCALENDAR")
+	iw.line("VERSION:2.0")
+	iw.line("PRODID:" + icsProdID)
+	iw.line("CALSCALE:GREGORIAN")
+	iw.line("METHOD:PUBLISH")
+
+	tzids := make([]string, 0, len(c.zones))
+	for tzid := range c.zones {
+		tzids = append(tzids, tzid)
+	}
+	sort.Strings(tzids)
+	for _, tzid := range tzids {
+		c.writeVTimezone(iw, tzid)
+	}
+
+	for _, ev := range c.events {
+		c.writeVEvent(iw, ev)
+	}
+
+	iw.line("END:VCALENDAR")
+
+	if iw.err == nil {
+		iw.err = iw.w.Flush()
+	}
+	return iw.n, iw.err
+}
+
+func (c *icsCalendar) writeVEvent(iw *icsWriter, ev *icsEvent) {
+	e := &ev.event
+
+	iw.line("BEGIN:VEVENT")
+	iw.line("UID:" + ev.uid)
+	iw.line("DTSTAMP:" + c.now.UTC().Format(icsTimeFormat) + "Z")
+	iw.line(icsDateTimeProperty("DTSTART", e.Start, ev.tzid))
+	iw.line(icsDateTimeProperty("DTEND", e.End, ev.tzid))
+	for _, r := range e.Recurrence {
+		iw.line(icsRecurrence(r, ev.tzid))
+	}
+	for _, exdate := range ev.exdates {
+		switch ev.tzid {
+		case "":
+			iw.line("EXDATE;VALUE=DATE:" + exdate)
+		case "UTC":
+			iw.line("EXDATE:" + exdate + "Z")
+		default:
+			iw.line("EXDATE;TZID=" + ev.tzid + ":" + exdate)
+		}
+	}
+	iw.line("SUMMARY:" + icsEscape(e.Summary))
+	if e.Description != "" {
+		iw.line("DESCRIPTION:" + icsEscape(e.Description))
+	}
+	switch e.Status {
+	case "tentative":
+		iw.line("STATUS:TENTATIVE")
+	default:
+		iw.line("STATUS:CONFIRMED")
+	}
+	if e.Visibility == "private" {
+		iw.line("CLASS:PRIVATE")
+	}
+	if e.Transparency == "transparent" {
+		iw.line("TRANSP:TRANSPARENT")
+	} else {
+		iw.line("TRANSP:OPAQUE")
+	}
+	for _, a := range e.Attachments {
+		params := ""
+		if a.MimeType != "" {
+			params += ";FMTTYPE=" + icsParamValue(a.MimeType)
+		}
+		if a.Title != "" {
+			params += ";FILENAME=" + icsParamValue(a.Title)
+		}
+		iw.line("ATTACH" + params + ":" + a.FileUrl)
+	}
+	if e.Reminders != nil && !e.Reminders.UseDefault {
+		if c.extendedProperties {
+			iw.line("X-GRN2GCAL-REMINDERS:override")
+		}
+		for _, o := range e.Reminders.Overrides {
+			iw.line("BEGIN:VALARM")
+			iw.line("ACTION:DISPLAY")
+			iw.line("DESCRIPTION:" + icsEscape(e.Summary))
+			iw.line(fmt.Sprintf("TRIGGER:-PT%dM", o.Minutes))
+			if c.extendedProperties {
+				iw.line("X-GRN2GCAL-METHOD:" + o.Method)
+			}
+			iw.line("END:VALARM")
+		}
+	}
+	if c.extendedProperties && e.ExtendedProperties != nil {
+		keys := make([]string, 0, len(e.ExtendedProperties.Private))
+		for k := range e.ExtendedProperties.Private {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			iw.line("X-GRN2GCAL-PRIVATE:" + icsEscape(k+"="+e.ExtendedProperties.Private[k]))
+		}
+	}
+	iw.line("END:VEVENT")
+}
+
+// writes a VTIMEZONE with offsets transitioning between the times of events
+func (c *icsCalendar) writeVTimezone(iw *icsWriter, tzid string) {
+	loc, err := time.LoadLocation(tzid)
+	if err != nil {
+		return
+	}
+	span := c.zones[tzid]
+
+	from := span.from.In(loc).AddDate(0, 0, -1)
+	to := span.to.In(loc).AddDate(0, 0, 1)
+
+	iw.line("BEGIN:VTIMEZONE")
+	iw.line("TZID:" + tzid)
+
+	// the offset at the beginning
+	name, offset := from.Zone()
+	writeTZComponent(iw, from.IsDST(), from, offset, offset, name)
+
+	// transitions
+	for t := from; t.Before(to); {
+		next := t.Add(24 * time.Hour)
+		if _, nextOffset := next.Zone(); nextOffset != offset {
+			// the first second of the new offset
+			lo, hi := t, next
+			for hi.Sub(lo) > time.Second {
+				mid := lo.Add(hi.Sub(lo) / 2)
+				if _, o := mid.Zone(); o == offset {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			name, nextOffset := hi.Zone()
+			writeTZComponent(iw, hi.IsDST(), hi, offset, nextOffset, name)
+			offset = nextOffset
+		}
+		t = next
+	}
+
+	iw.line("END:VTIMEZONE")
+}
+
+// a STANDARD or DAYLIGHT component.
+// DTSTART is the local time in the offset before the transition.
+func writeTZComponent(iw *icsWriter, dst bool, at time.Time, offsetFrom, offsetTo int, name string) {
+	component := "STANDARD"
+	if dst {
+		component = "DAYLIGHT"
+	}
+
+	iw.line("BEGIN:" + component)
+	iw.line("DTSTART:" + at.UTC().Add(time.Duration(offsetFrom)*time.Second).Format(icsTimeFormat))
+	iw.line("TZOFFSETFROM:" + icsOffset(offsetFrom))
+	iw.line("TZOFFSETTO:" + icsOffset(offsetTo))
+	if name != "" {
+		iw.line("TZNAME:" + icsEscape(name))
+	}
+	iw.line("END:" + component)
+}
+
+// such as "+0900"
+func icsOffset(offset int) string {
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	return fmt.Sprintf("%c%02d%02d", sign, offset/3600, offset%3600/60)
+}
+
+// DTSTART/DTEND with DATE or DATE-TIME in TZID
+func icsDateTimeProperty(name string, edt *calendar.EventDateTime, tzid string) string {
+	if edt.DateTime == "" {
+		return name + ";VALUE=DATE:" + strings.Replace(edt.Date, "-", "", -1)
+	}
+
+	dt, err := time.Parse(time.RFC3339, edt.DateTime)
+	if err != nil {
+		return name + ":" + edt.DateTime
+	}
+	if tzid == "UTC" {
+		return name + ":" + dt.UTC().Format(icsTimeFormat) + "Z"
+	}
+	loc, err := time.LoadLocation(tzid)
+	if err != nil {
+		return name + ":" + dt.UTC().Format(icsTimeFormat) + "Z"
+	}
+	return name + ";TZID=" + tzid + ":" + dt.In(loc).Format(icsTimeFormat)
+}
+
+// an RRULE of Gcal into that of RFC 5545.
+// UNTIL must be a UTC DATE-TIME when DTSTART is a DATE-TIME.
+func icsRecurrence(rrule, tzid string) string {
+	until, found := rruleUntil(rrule)
+	if !found || tzid == "" || len(until) != len(icsDateFormat) {
+		return rrule
+	}
+
+	loc, err := time.LoadLocation(tzid)
+	if err != nil {
+		loc = time.UTC
+	}
+	untilDate, err := time.ParseInLocation(icsDateFormat, until, loc)
+	if err != nil {
+		return rrule
+	}
+	// until the end of the day
+	untilDT := untilDate.AddDate(0, 0, 1).Add(-time.Second).UTC().Format(icsTimeFormat) + "Z"
+
+	return strings.Replace(rrule, "UNTIL="+until, "UNTIL="+untilDT, 1)
+}
+
+// the value of UNTIL in an RRULE
+func rruleUntil(rrule string) (string, bool) {
+	i := strings.Index(rrule, "UNTIL=")
+	if i < 0 {
+		return "", false
+	}
+
+	until := rrule[i+len("UNTIL="):]
+	if j := strings.IndexByte(until, ';'); j >= 0 {
+		until = until[:j]
+	}
+	return until, true
+}
+
+// quotes a parameter value if needed
+func icsParamValue(s string) string {
+	s = strings.Replace(s, `"`, "'", -1)
+	if strings.ContainsAny(s, ";:,") {
+		return `"` + s + `"`
+	}
+	return s
+}
+
+// escapes TEXT values
+func icsEscape(s string) string {
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\n", `\n`,
+	).Replace(s)
+}
+
+// icsWriter ...
+// writes content lines folded at 75 octets
+type icsWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (iw *icsWriter) line(s string) {
+	if iw.err != nil {
+		return
+	}
+
+	for first := true; first || len(s) > 0; first = false {
+		limit := 75
+		if !first {
+			limit = 74 // a leading space
+			iw.write(" ")
+		}
+
+		cut := len(s)
+		if cut > limit {
+			cut = limit
+			for cut > 0 && !utf8.RuneStart(s[cut]) {
+				cut--
+			}
+		}
+		iw.write(s[:cut])
+		iw.write("\r\n")
+		s = s[cut:]
+	}
+}
+
+func (iw *icsWriter) write(s string) {
+	if iw.err != nil {
+		return
+	}
+
+	n, err := iw.w.WriteString(s)
+	iw.n += int64(n)
+	iw.err = err
+}
+
+// parseICSEvents ...
+// parses VEVENTs into Gcal events; the reverse of writeVEvent.
+// UID goes to ICalUID.
+func parseICSEvents(data string) ([]*calendar.Event, error) {
+	// unfold
+	data = strings.Replace(data, "\r\n", "\n", -1)
+	data = strings.Replace(data, "\n ", "", -1)
+	data = strings.Replace(data, "\n\t", "", -1)
+
+	var events []*calendar.Event
+	var ev *calendar.Event
+	var alarm *calendar.EventReminder
+	var rrules []string
+	duration := ""
+	overridden := false
+
+	for _, line := range strings.Split(data, "\n") {
+		if line == "" {
+			continue
+		}
+		name, params, value, err := parseICSLine(line)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			ev = &calendar.Event{
+				ExtendedProperties: &calendar.EventExtendedProperties{
+					Private: make(map[string]string),
+				},
+			}
+			rrules = nil
+			duration = ""
+			overridden = false
+
+		case ev == nil:
+			// out of VEVENT
+
+		case name == "END" && value == "VEVENT":
+			if ev.Start == nil {
+				// DTSTART is required
+				ev = nil
+				continue
+			}
+			if ev.End == nil {
+				ev.End, err = icsEventEnd(ev.Start, duration)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			tzid := ""
+			if ev.Start != nil && ev.Start.DateTime != "" {
+				tzid = ev.Start.TimeZone
+				if tzid == "" {
+					tzid = "UTC"
+				}
+			}
+			for _, r := range rrules {
+				ev.Recurrence = append(ev.Recurrence, gcalRecurrence(r, tzid))
+			}
+			if overridden {
+				if ev.Reminders == nil {
+					ev.Reminders = &calendar.EventReminders{}
+				}
+			} else {
+				ev.Reminders = nil
+			}
+			events = append(events, ev)
+			ev = nil
+
+		case name == "BEGIN" && value == "VALARM":
+			alarm = &calendar.EventReminder{Method: "popup"}
+
+		case name == "END" && value == "VALARM":
+			if alarm != nil {
+				if ev.Reminders == nil {
+					ev.Reminders = &calendar.EventReminders{}
+				}
+				ev.Reminders.Overrides = append(ev.Reminders.Overrides, alarm)
+			}
+			alarm = nil
+
+		case alarm != nil:
+			switch name {
+			case "TRIGGER":
+				d, err := parseICSDuration(value)
+				if err != nil {
+					return nil, err
+				}
+				alarm.Minutes = int64(-d / time.Minute)
+			case "X-GRN2GCAL-METHOD":
+				alarm.Method = value
+			}
+
+		default:
+			switch name {
+			case "UID":
+				ev.ICalUID = value
+			case "DTSTART":
+				ev.Start, err = parseICSDateTime(params, value)
+			case "DTEND":
+				ev.End, err = parseICSDateTime(params, value)
+			case "DURATION":
+				duration = value
+			case "RRULE":
+				rrules = append(rrules, "RRULE:"+value)
+			case "SUMMARY":
+				ev.Summary = icsUnescape(value)
+			case "DESCRIPTION":
+				ev.Description = icsUnescape(value)
+			case "STATUS":
+				ev.Status = strings.ToLower(value)
+			case "CLASS":
+				if value != "PUBLIC" {
+					ev.Visibility = strings.ToLower(value)
+				}
+			case "TRANSP":
+				ev.Transparency = strings.ToLower(value)
+			case "ATTACH":
+				ev.Attachments = append(ev.Attachments, &calendar.EventAttachment{
+					FileUrl:  value,
+					Title:    params["FILENAME"],
+					MimeType: params["FMTTYPE"],
+				})
+			case "X-GRN2GCAL-REMINDERS":
+				overridden = (value == "override")
+			case "X-GRN2GCAL-PRIVATE":
+				kv := strings.SplitN(icsUnescape(value), "=", 2)
+				if len(kv) == 2 {
+					ev.ExtendedProperties.Private[kv[0]] = kv[1]
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return events, nil
+}
+
+// NAME;PARAM=VALUE:VALUE
+func parseICSLine(line string) (string, map[string]string, string, error) {
+	params := make(map[string]string)
+
+	// the first colon out of quotes
+	quoted := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return "", nil, "", fmt.Errorf("invalid iCalendar line %q", line)
+	}
+
+	head, value := line[:colon], line[colon+1:]
+	parts := strings.Split(head, ";")
+	for _, p := range parts[1:] {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) == 2 {
+			params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return strings.ToUpper(parts[0]), params, value, nil
+}
+
+// DATE or DATE-TIME into a Gcal date or datetime
+func parseICSDateTime(params map[string]string, value string) (*calendar.EventDateTime, error) {
+	if params["VALUE"] == "DATE" || len(value) == len(icsDateFormat) {
+		d, err := time.Parse(icsDateFormat, value)
+		if err != nil {
+			return nil, err
+		}
+		return &calendar.EventDateTime{Date: d.Format("2006-01-02")}, nil
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		dt, err := time.Parse(icsTimeFormat, strings.TrimSuffix(value, "Z"))
+		if err != nil {
+			return nil, err
+		}
+		return &calendar.EventDateTime{DateTime: dt.Format(time.RFC3339)}, nil
+	}
+
+	loc := time.Local
+	tzid := params["TZID"]
+	if tzid != "" {
+		var err error
+		loc, err = time.LoadLocation(tzid)
+		if err != nil {
+			return nil, err
+		}
+	}
+	dt, err := time.ParseInLocation(icsTimeFormat, value, loc)
+	if err != nil {
+		return nil, err
+	}
+	return &calendar.EventDateTime{DateTime: dt.Format(time.RFC3339), TimeZone: tzid}, nil
+}
+
+// the end of a VEVENT without DTEND (RFC 5545 3.6.1):
+// DTSTART plus DURATION, or the next day of a DATE, or DTSTART itself.
+func icsEventEnd(start *calendar.EventDateTime, duration string) (*calendar.EventDateTime, error) {
+	var d time.Duration
+	if duration != "" {
+		var err error
+		d, err = parseICSDuration(duration)
+		if err != nil {
+			return nil, err
+		}
+	} else if start.DateTime == "" {
+		d = 24 * time.Hour
+	}
+
+	if start.DateTime == "" {
+		startDate, err := time.Parse("2006-01-02", start.Date)
+		if err != nil {
+			return nil, err
+		}
+		return &calendar.EventDateTime{Date: startDate.AddDate(0, 0, int(d/(24*time.Hour))).Format("2006-01-02")}, nil
+	}
+
+	startDT, err := time.Parse(time.RFC3339, start.DateTime)
+	if err != nil {
+		return nil, err
+	}
+	return &calendar.EventDateTime{DateTime: startDT.Add(d).Format(time.RFC3339), TimeZone: start.TimeZone}, nil
+}
+
+// the reverse of icsRecurrence
+func gcalRecurrence(rrule, tzid string) string {
+	until, found := rruleUntil(rrule)
+	if !found || tzid == "" || !strings.HasSuffix(until, "Z") {
+		return rrule
+	}
+
+	loc, err := time.LoadLocation(tzid)
+	if err != nil {
+		loc = time.UTC
+	}
+	untilDT, err := time.Parse(icsTimeFormat, strings.TrimSuffix(until, "Z"))
+	if err != nil {
+		return rrule
+	}
+
+	return strings.Replace(rrule, "UNTIL="+until, "UNTIL="+untilDT.In(loc).Format(icsDateFormat), 1)
+}
+
+// such as "-PT15M", "-P1D"
+func parseICSDuration(value string) (time.Duration, error) {
+	s := value
+	sign := time.Duration(1)
+	if strings.HasPrefix(s, "-") {
+		sign = -1
+		s = s[1:]
+	}
+	s = strings.TrimPrefix(s, "+")
+	if !strings.HasPrefix(s, "P") {
+		return 0, fmt.Errorf("invalid iCalendar duration %q", value)
+	}
+	s = s[1:]
+
+	// W and D before T, H, M and S after it; no years or months (P1M)
+	var d time.Duration
+	num, digits, inTime := 0, false, false
+	for _, r := range s {
+		if '0' <= r && r <= '9' {
+			num, digits = num*10+int(r-'0'), true
+			continue
+		}
+
+		var unit time.Duration
+		switch {
+		case r == 'T' && !inTime && !digits:
+			inTime = true
+			continue
+		case r == 'W' && !inTime:
+			unit = 7 * 24 * time.Hour
+		case r == 'D' && !inTime:
+			unit = 24 * time.Hour
+		case r == 'H' && inTime:
+			unit = time.Hour
+		case r == 'M' && inTime:
+			unit = time.Minute
+		case r == 'S' && inTime:
+			unit = time.Second
+		default:
+			return 0, fmt.Errorf("invalid iCalendar duration %q", value)
+		}
+		if !digits {
+			return 0, fmt.Errorf("invalid iCalendar duration %q", value)
+		}
+		d, num, digits = d+time.Duration(num)*unit, 0, false
+	}
+	if digits || s == "" || strings.HasSuffix(s, "T") {
+		return 0, fmt.Errorf("invalid iCalendar duration %q", value)
+	}
+	return sign * d, nil
+}
+
+// the reverse of icsEscape
+func icsUnescape(s string) string {
+	return strings.NewReplacer(
+		`\\`, `\`,
+		`\;`, ";",
+		`\,`, ",",
+		`\n`, "\n",
+		`\N`, "\n",
+	).Replace(s)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseICSEventsWithoutDTEND(t *testing.T) {
+	ics := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"BEGIN:VEVENT",
+		"UID:duration@example.com",
+		"DTSTART;TZID=Asia/Tokyo:20200608T100000",
+		"DURATION:PT1H30M",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:date@example.com",
+		"DTSTART;VALUE=DATE:20200609",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:datetime@example.com",
+		"DTSTART:20200610T010000Z",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:nostart@example.com",
+		"SUMMARY:no DTSTART",
+		"END:VEVENT",
+		"END:VCALENDAR",
+		"",
+	}, "\r\n")
+
+	events, err := parseICSEvents(ics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("len = %d, want 3 (without DTSTART skipped)", len(events))
+	}
+
+	for i, want := range []string{"2020-06-08T11:30:00+09:00", "2020-06-10", "2020-06-10T01:00:00Z"} {
+		start, end, err := getGcalTimeSpan(events[i])
+		if err != nil {
+			t.Errorf("%s: %v", events[i].ICalUID, err)
+			continue
+		}
+		if end != want {
+			t.Errorf("%s: %s - %s, want the end %s", events[i].ICalUID, start, end, want)
+		}
+	}
+}
+
+func TestParseICSDuration(t *testing.T) {
+	for _, c := range []struct {
+		value string
+		want  time.Duration
+	}{
+		{"PT15M", 15 * time.Minute},
+		{"-PT15M", -15 * time.Minute},
+		{"+PT1H30M", 90 * time.Minute},
+		{"PT1H0M10S", time.Hour + 10*time.Second},
+		{"P1D", 24 * time.Hour},
+		{"-P1DT12H", -36 * time.Hour},
+		{"P2W", 14 * 24 * time.Hour},
+	} {
+		got, err := parseICSDuration(c.value)
+		if err != nil {
+			t.Errorf("%s: %v", c.value, err)
+		} else if got != c.want {
+			t.Errorf("%s = %v, want %v", c.value, got, c.want)
+		}
+	}
+
+	// months and years are not durations; units out of place
+	for _, value := range []string{"", "15M", "P1M", "P1Y", "PT1D", "P1H", "P1DT", "PT", "PT15", "PTM", "P", "P1TT1H", "PT1H1W"} {
+		if d, err := parseICSDuration(value); err == nil {
+			t.Errorf("%q = %v, want an error", value, d)
+		}
+	}
+}
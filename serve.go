@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+ * memo
+ *  GET /calendar.ics?token=...
+ *    every serve.cache_interval: fetch Garoon events of all profiles
+ *    If-None-Match == ETag: 304
+ */
+
+// icsServer ...
+// serves Garoon events as an iCalendar feed
+type icsServer struct {
+	config   *Config
+	profiles []ProfileConfig
+
+	mu sync.RWMutex
+	// the latest feed; nil until the first fetch succeeds
+	body []byte
+	etag string
+	// DTSTAMP, renewed when the contents change
+	stamp time.Time
+}
+
+// serveICS ...
+// runs an HTTP server of an iCalendar feed
+func serveICS(config *Config, profiles []ProfileConfig) error {
+	if config.Serve.Token == "" {
+		return errors.New("config validattion error: serve.token is missing")
+	}
+
+	s := &icsServer{
+		config:   config,
+		profiles: profiles,
+	}
+
+	go func() {
+		interval := config.Serve.GetCacheInterval()
+		for {
+			if err := s.refresh(context.Background(), time.Now()); err != nil {
+				log.Printf("Failed to fetch Garoon events: %v\n", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle("/calendar.ics", s)
+
+	log.Printf("Serving http://%s/calendar.ics?token=...\n", config.Serve.GetAddr())
+	return http.ListenAndServe(config.Serve.GetAddr(), mux)
+}
+
+// fetch Garoon events and renew the feed
+func (s *icsServer) refresh(ctx context.Context, now time.Time) error {
+	syncStart, syncEnd := syncSpan(now)
+
+	s.mu.RLock()
+	stamp := s.stamp
+	s.mu.RUnlock()
+	if stamp.IsZero() {
+		stamp = now
+	}
+
+	cal := newICSCalendar(stamp)
+	for i := range s.profiles {
+		profileConfig := s.config.ApplyProfile(&s.profiles[i])
+		if err := fetchICSEvents(ctx, &profileConfig, cal, syncStart, syncEnd); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := cal.WriteTo(&buf); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.body != nil && bytes.Equal(buf.Bytes(), s.body) {
+		return nil
+	}
+
+	// changed; stamp the new contents
+	if s.body != nil {
+		cal.now = now
+		buf.Reset()
+		if _, err := cal.WriteTo(&buf); err != nil {
+			return err
+		}
+	}
+
+	s.body = buf.Bytes()
+	s.etag = fmt.Sprintf(`"%x"`, sha256.Sum256(s.body))
+	s.stamp = cal.now
+	log.Printf("Fetched Garoon events (%d bytes)\n", len(s.body))
+
+	return nil
+}
+
+func (s *icsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Serve.Token)) != 1 {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	s.mu.RLock()
+	body, etag := s.body, s.etag
+	s.mu.RUnlock()
+
+	if body == nil {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "not fetched yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, max-age=0")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(body)
+}
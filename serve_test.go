@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestICSServer(t *testing.T) {
+	now := time.Now()
+	csvFile := filepath.Join(t.TempDir(), "schedule.csv")
+	writeCSV := func(title string) {
+		t.Helper()
+
+		day := now.AddDate(0, 0, 1).Format("2006/01/02")
+		data := "開始日付,開始時刻,終了日付,終了時刻,予定,予定詳細,メモ\n" +
+			day + ",10:00:00," + day + ",11:00:00,会議," + title + ",\n"
+		if err := os.WriteFile(csvFile, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := &Config{
+		Garoon: GaroonConfig{CSVFile: csvFile, TimeZone: "UTC"},
+		Serve:  ServeConfig{Token: "secret"},
+	}
+	s := &icsServer{config: config, profiles: []ProfileConfig{{}}}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	get := func(method, token, etag string) (*http.Response, string) {
+		t.Helper()
+
+		req, err := http.NewRequest(method, server.URL+"/calendar.ics?token="+token, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	// not fetched yet
+	if resp, _ := get(http.MethodGet, "secret", ""); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status = %d before the first fetch", resp.StatusCode)
+	}
+
+	writeCSV("定例")
+	if err := s.refresh(context.Background(), now); err != nil {
+		t.Fatal(err)
+	}
+
+	// token
+	for _, token := range []string{"", "wrong", "secret2"} {
+		if resp, _ := get(http.MethodGet, token, ""); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("token %q: status = %d", token, resp.StatusCode)
+		}
+	}
+	if resp, _ := get(http.MethodPost, "secret", ""); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status = %d", resp.StatusCode)
+	}
+
+	// GET
+	resp, body := get(http.MethodGet, "secret", "")
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" || !strings.Contains(body, "定例") {
+		t.Fatalf("GET: status = %d, ETag = %q, body = %q", resp.StatusCode, etag, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/calendar") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	// If-None-Match
+	if resp, body := get(http.MethodGet, "secret", etag); resp.StatusCode != http.StatusNotModified || body != "" {
+		t.Errorf("If-None-Match: status = %d, body = %q", resp.StatusCode, body)
+	}
+
+	// HEAD
+	if resp, body := get(http.MethodHead, "secret", ""); resp.StatusCode != http.StatusOK || body != "" || resp.Header.Get("ETag") != etag {
+		t.Errorf("HEAD: status = %d, ETag = %q, body = %q", resp.StatusCode, resp.Header.Get("ETag"), body)
+	}
+
+	// refreshed without changes
+	if err := s.refresh(context.Background(), now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := get(http.MethodGet, "secret", etag); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("status = %d after a refresh without changes", resp.StatusCode)
+	}
+
+	// refreshed with changes
+	writeCSV("臨時")
+	if err := s.refresh(context.Background(), now.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	resp, body = get(http.MethodGet, "secret", etag)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") == etag || !strings.Contains(body, "臨時") {
+		t.Errorf("status = %d, ETag = %q after a change", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+/*
+ * memo
+ *  the sync engine reads events through EventSource as SourceEvents,
+ *  and writes changes made in Gcal back through EventWriter if a source is one.
+ *  a Garoon source (the API or a CSV export) is the only implementation so far;
+ *  only the API is an EventWriter.
+ */
+
+// EventSource ...
+// a calendar events are synced from
+type EventSource interface {
+	// the user whose events are synced
+	CurrentUser(ctx context.Context) (string, error)
+	// events between start and end
+	ListEvents(ctx context.Context, start, end time.Time) ([]*SourceEvent, error)
+	// an event, or nil if not found
+	GetEvent(ctx context.Context, id string) (*SourceEvent, error)
+}
+
+// EventWriter ...
+// an EventSource changes made in Gcal can be written back to
+type EventWriter interface {
+	EventSource
+
+	// overwrites an event with contents of a Gcal event,
+	// or returns errEventNotWritable
+	ModifyEvent(ctx context.Context, id string, gcalEvent *calendar.Event, reverseConfig *ReverseConfig) error
+	// the current user leaves an event
+	LeaveEvent(ctx context.Context, id string) error
+}
+
+// an event of a kind that cannot be written back, such as a banner
+var errEventNotWritable = errors.New("the event cannot be written back")
+
+// kinds of SourceEvent
+const (
+	SourceEventSingle    string = "single"
+	SourceEventRecurring string = "recurring"
+	SourceEventTentative string = "tentative" // candidates in Spans
+)
+
+// SourceEvent ...
+// an event independent of groupware
+type SourceEvent struct {
+	ID          string
+	Kind        string
+	Category    string // such as a Garoon plan (menu)
+	Title       string
+	Description string
+	Private     bool
+	AllDay      bool // such as Garoon "終日"; Spans may still be datetimes
+	StartOnly   bool
+
+	// IANA names
+	TimeZone    string
+	EndTimeZone string
+
+	// one span, or candidates of a tentative event.
+	// a recurring event has its first occurrence.
+	Spans []SourceSpan
+	// RRULE lines of a recurring event
+	Recurrence []string
+	// starts of excluded occurrences
+	Exclusions []time.Time
+
+	// user IDs
+	Attendees   []string
+	Comments    []*SourceComment
+	Attachments []*SourceAttachment
+}
+
+// SourceSpan ...
+// RFC3339 datetimes, or dates (2006-01-02)
+type SourceSpan struct {
+	Start string
+	End   string
+
+	// Start and End are dates
+	Date bool
+}
+
+// SourceComment ...
+// a follow-up of an event
+type SourceComment struct {
+	Author string
+	Date   time.Time
+	// as it is in the source, shown if Date is zero
+	RawDate string
+	Text    string
+}
+
+// SourceAttachment ...
+// a file of an event
+type SourceAttachment struct {
+	Name     string
+	Size     int64
+	MimeType string
+	URL      string
+}
+
+// whether a user attends an event
+func isAttendeeOfSourceEvent(userID string, ev *SourceEvent) bool {
+	if ev == nil {
+		return false
+	}
+
+	for _, attendee := range ev.Attendees {
+		if userID == attendee {
+			return true
+		}
+	}
+	return false
+}
+
+// garoonEventSource ...
+// Garoon events through the API or a CSV export
+type garoonEventSource struct {
+	grn garoonSource
+	// the API; nil for a CSV export
+	live *Service
+
+	// fetch follow-ups and attachments
+	details bool
+}
+
+// garoonAPISource ...
+// Garoon events through the API, which can be written back
+type garoonAPISource struct {
+	*garoonEventSource
+}
+
+// garoonBacked ...
+// an EventSource on the Garoon API, for features only Garoon has (sync.reverse)
+type garoonBacked interface {
+	garoon() *Service
+}
+
+// creates an event source of a config; garoon.csv_file or the API
+func newEventSource(config *Config) (EventSource, error) {
+	details := config.Sync.Follows > 0 || (config.Sync.Attachments != "" && config.Sync.Attachments != AttachmentsOff)
+
+	if config.Garoon.CSVFile != "" {
+		grnCSV, err := NewGaroonCSV(config.Garoon.CSVFile, config.Garoon.TimeZone)
+		if err != nil {
+			return nil, err
+		}
+		return &garoonEventSource{grn: grnCSV, details: details}, nil
+	}
+
+	live, err := newGaroonService(config)
+	if err != nil {
+		return nil, err
+	}
+	return &garoonAPISource{&garoonEventSource{grn: live, live: live, details: details}}, nil
+}
+
+func (s *garoonAPISource) garoon() *Service {
+	return s.live
+}
+
+func (s *garoonAPISource) ModifyEvent(ctx context.Context, id string, gcalEvent *calendar.Event, reverseConfig *ReverseConfig) error {
+	grnEventList, err := s.live.ScheduleGetEventsByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if len(grnEventList.Events) == 0 {
+		return fmt.Errorf("no Garoon event(ID=%v)", id)
+	}
+
+	// only normal events can be modified through the API
+	grnEvent := grnEventList.Events[0]
+	if grnEvent.EventType != GaroonEventTypeNormal {
+		return errEventNotWritable
+	}
+
+	_, err = modifyGrnEventByGcal(ctx, s.live, grnEvent, gcalEvent, reverseConfig, grnEvent.TimeZone)
+	return err
+}
+
+func (s *garoonAPISource) LeaveEvent(ctx context.Context, id string) error {
+	_, err := s.live.ScheduleLeaveEvents(ctx, id)
+	return err
+}
+
+func (s *garoonEventSource) CurrentUser(ctx context.Context) (string, error) {
+	targetUser, err := s.grn.UtilGetLoginUserID(ctx)
+	if err != nil {
+		return "", err
+	}
+	return targetUser.UserID, nil
+}
+
+func (s *garoonEventSource) ListEvents(ctx context.Context, start, end time.Time) ([]*SourceEvent, error) {
+	grnEventList, err := s.grn.ScheduleGetEvents(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	// follow-ups and attachments come with ScheduleGetEventsById
+	// (one by one; an event without them would strip them from Gcal)
+	if s.details && s.live != nil {
+		detailedEvents := grnEventList.Events[:0]
+		for _, grnEvent := range grnEventList.Events {
+			detailed, err := s.grn.ScheduleGetEventsByID(ctx, grnEvent.ID)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to fetch a Garoon event(ID=%v): %v", grnEvent.ID, err)
+			}
+			if len(detailed.Events) == 0 {
+				// deleted or hidden since listed
+				log.Printf("Garoon event(ID=%v) is gone; skipped\n", grnEvent.ID)
+				continue
+			}
+			grnEvent.Follows = detailed.Events[0].Follows
+			grnEvent.Files = detailed.Events[0].Files
+			detailedEvents = append(detailedEvents, grnEvent)
+		}
+		grnEventList.Events = detailedEvents
+	}
+
+	events := make([]*SourceEvent, 0, len(grnEventList.Events))
+	for _, grnEvent := range grnEventList.Events {
+		ev, err := s.convert(grnEvent)
+		if err != nil {
+			log.Printf("Failed to convert Garoon event(ID=%v): %v\n", grnEvent.ID, err)
+			continue
+		}
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+func (s *garoonEventSource) GetEvent(ctx context.Context, id string) (*SourceEvent, error) {
+	grnEventList, err := s.grn.ScheduleGetEventsByID(ctx, id)
+	var fault *GaroonFault
+	if errors.As(err, &fault) && fault.IsNotFound() {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(grnEventList.Events) == 0 {
+		// deleted or not open to the user
+		return nil, nil
+	}
+
+	return s.convert(grnEventList.Events[0])
+}
+
+func (s *garoonEventSource) convert(grnEvent *GaroonEvent) (*SourceEvent, error) {
+	if s.live != nil {
+		for _, f := range grnEvent.Files {
+			f.URL = s.live.FileDownloadURL(grnEvent.ID, f.ID)
+		}
+	}
+
+	return convertGrnEventIntoSourceEvent(grnEvent)
+}
+
+// convertGrnEventIntoSourceEvent ...
+// datetimes are in the event time zone
+func convertGrnEventIntoSourceEvent(grnEvent *GaroonEvent) (*SourceEvent, error) {
+	ev := &SourceEvent{
+		ID:          grnEvent.ID,
+		Kind:        SourceEventSingle,
+		Category:    grnEvent.Plan,
+		Title:       grnEvent.Detail,
+		Description: grnEvent.Description,
+		Private:     grnEvent.IsPrivate(),
+		AllDay:      isAllDayGrnEvent(grnEvent),
+		StartOnly:   grnEvent.StartOnly,
+		TimeZone:    grnEvent.TimeZone,
+		EndTimeZone: grnEvent.EndTimeZone,
+	}
+	if ev.EndTimeZone == "" {
+		ev.EndTimeZone = ev.TimeZone
+	}
+
+	for _, m := range grnEvent.Members {
+		ev.Attendees = append(ev.Attendees, m.ID)
+	}
+	for _, f := range grnEvent.Follows {
+		date, _ := time.Parse(time.RFC3339, f.Creator.Date)
+		ev.Comments = append(ev.Comments, &SourceComment{Author: f.Creator.Name, Date: date, RawDate: f.Creator.Date, Text: f.Text})
+	}
+	for _, f := range grnEvent.Files {
+		ev.Attachments = append(ev.Attachments, &SourceAttachment{Name: f.Name, Size: f.Size, MimeType: f.MimeType, URL: f.URL})
+	}
+
+	switch grnEvent.EventType {
+	case GaroonEventTypeRepeat:
+		r, s, e := convertGrnRecurrenceIntoGcalRecurrence(grnEvent)
+		if r == nil && s == nil && e == nil {
+			return nil, fmt.Errorf("failed to convert recurrence of %s %s", formatAsGcalSummary(grnEvent.Plan, grnEvent.Detail), grnEvent.ID)
+		}
+		ev.Kind = SourceEventRecurring
+		ev.Recurrence = r
+		ev.Spans = []SourceSpan{{Start: s.DateTime, End: e.DateTime}}
+
+		for _, ex := range grnEvent.Repeat.Exclusive {
+			exDT, err := time.Parse(time.RFC3339, ex.Start)
+			if err != nil {
+				log.Printf("Failed to parse Garoon exclusive datetime(%s): %v\n", ex.Start, err)
+				continue
+			}
+			ev.Exclusions = append(ev.Exclusions, exDT)
+		}
+
+	case GaroonEventTypeBanner:
+		startDate, endDate, err := getGrnBannerDateSpan(grnEvent)
+		if err != nil {
+			return nil, err
+		}
+		ev.Spans = []SourceSpan{{Start: startDate, End: endDate, Date: true}}
+
+	case GaroonEventTypeTemporary:
+		ev.Kind = SourceEventTentative
+		for i := range grnEvent.Datetime {
+			candidate := *grnEvent
+			candidate.Datetime = grnEvent.Datetime[i : i+1]
+
+			span, err := getGrnSourceSpan(&candidate)
+			if err != nil {
+				return nil, err
+			}
+			ev.Spans = append(ev.Spans, span)
+		}
+
+	default:
+		span, err := getGrnSourceSpan(grnEvent)
+		if err != nil {
+			return nil, err
+		}
+		if span.Start != "" {
+			ev.Spans = []SourceSpan{span}
+		}
+	}
+
+	return ev, nil
+}
+
+// the span of a normal event or a candidate.
+// an allday (終日) event becomes dates like a banner.
+func getGrnSourceSpan(grnEvent *GaroonEvent) (SourceSpan, error) {
+	if grnEvent.AllDay && len(grnEvent.Datetime) > 0 {
+		startDate, endDate, err := getGrnBannerDateSpan(grnEvent)
+		if err != nil {
+			return SourceSpan{}, err
+		}
+		return SourceSpan{Start: startDate, End: endDate, Date: true}, nil
+	}
+
+	start, end, err := getGrnTimeSpan(grnEvent)
+	if err != nil {
+		return SourceSpan{}, err
+	}
+	return SourceSpan{Start: start, End: end, Date: len(grnEvent.Datetime) == 0 && len(grnEvent.Date) > 0}, nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memorySource ...
+// events in memory; not an EventWriter
+type memorySource struct {
+	user   string
+	events map[string]*SourceEvent
+}
+
+func (s *memorySource) CurrentUser(ctx context.Context) (string, error) {
+	return s.user, nil
+}
+
+func (s *memorySource) ListEvents(ctx context.Context, start, end time.Time) ([]*SourceEvent, error) {
+	var events []*SourceEvent
+	for _, ev := range s.events {
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+func (s *memorySource) GetEvent(ctx context.Context, id string) (*SourceEvent, error) {
+	return s.events[id], nil
+}
+
+func TestSyncMemorySource(t *testing.T) {
+	stub, server := newCalDAVStub(t)
+	target := newCalDAVTarget(&CalDAVConfig{URL: server.URL + "/calendars/user/memory"})
+	ctx := context.Background()
+	start, end := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	source := &memorySource{
+		user: "u1",
+		events: map[string]*SourceEvent{
+			"m1": {
+				ID: "m1", Kind: SourceEventSingle, Title: "review", TimeZone: "UTC", EndTimeZone: "UTC",
+				Spans:     []SourceSpan{{Start: "2020-06-08T01:00:00Z", End: "2020-06-08T02:00:00Z"}},
+				Attendees: []string{"u1"},
+			},
+			"m2": {
+				ID: "m2", Kind: SourceEventTentative, Title: "offsite", TimeZone: "UTC", EndTimeZone: "UTC",
+				Spans: []SourceSpan{
+					{Start: "2020-06-10", End: "2020-06-11", Date: true},
+					{Start: "2020-06-17", End: "2020-06-18", Date: true},
+				},
+				Attendees: []string{"u1"},
+			},
+		},
+	}
+	syncConfig := &SyncConfig{Conflict: ConflictGoogle}
+
+	syncAll := func() {
+		t.Helper()
+
+		var wg sync.WaitGroup
+		var updm sync.Mutex
+		srcEvents, _ := source.ListEvents(ctx, start, end)
+		for _, srcEvent := range srcEvents {
+			syncGrn2Gcal(ctx, srcEvent, target, source, syncConfig, &wg, &updm)
+		}
+
+		listed, err := target.List(ctx, start, end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, gcalEvent := range listed {
+			syncGcal2Grn(ctx, gcalEvent, target, source, source.user, syncConfig, &wg, &updm)
+		}
+		wg.Wait()
+	}
+	summaryOf := func(keys ...string) string {
+		t.Helper()
+
+		found, err := target.FindBySourceID(ctx, keys...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found == nil {
+			return ""
+		}
+		return found.Summary
+	}
+
+	// added
+	syncAll()
+	if len(stub.resources) != 3 {
+		t.Fatalf("%d events, want an event and 2 candidates", len(stub.resources))
+	}
+
+	// changed in the source
+	source.events["m1"].Title = "design review"
+	syncAll()
+	if got := summaryOf("garoon_event_id=m1"); got != "design review" {
+		t.Errorf("summary = %q after a change in the source", got)
+	}
+
+	// edited in the target; a memorySource cannot take it back
+	edited, _ := target.FindBySourceID(ctx, "garoon_event_id=m1")
+	edited.Summary = "edited"
+	if err := target.Update(ctx, edited); err != nil {
+		t.Fatal(err)
+	}
+	syncAll()
+	if got := summaryOf("garoon_event_id=m1"); got != "design review" {
+		t.Errorf("summary = %q, want overwritten by the source", got)
+	}
+
+	// confirmed
+	m2 := source.events["m2"]
+	m2.Kind = SourceEventSingle
+	m2.Spans = m2.Spans[1:]
+	syncAll()
+	if len(stub.resources) != 2 || summaryOf("garoon_event_id=m2") != "offsite" {
+		t.Errorf("%d events after confirming a candidate", len(stub.resources))
+	}
+
+	// deleted in the source
+	delete(source.events, "m1")
+	syncAll()
+	if len(stub.resources) != 1 || summaryOf("garoon_event_id=m1") != "" {
+		t.Errorf("%d events after a deletion in the source", len(stub.resources))
+	}
+}
+
+// a Garoon listing the fixture events 101 and 102, where 102 is gone and 103 fails
+func newVanishingGaroonServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		request := string(body)
+
+		w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+		switch {
+		case !strings.Contains(request, ">ScheduleGetEventsById</Action>"):
+			http.ServeFile(w, r, filepath.Join("testdata", "ScheduleGetEventsResponse_temporary.xml"))
+		case strings.Contains(request, ">101</event_id>"):
+			http.ServeFile(w, r, filepath.Join("testdata", "ScheduleGetEventsByIdResponse_confirmed.xml"))
+		case strings.Contains(request, ">102</event_id>"):
+			w.WriteHeader(http.StatusInternalServerError)
+			http.ServeFile(w, r, filepath.Join("testdata", "ScheduleGetEventsByIdResponse_fault.xml"))
+		default:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGaroonEventSourceGone(t *testing.T) {
+	server := newVanishingGaroonServer(t)
+	grn := NewGaroon("user", "password", server.URL, server.Client())
+	source := &garoonAPISource{&garoonEventSource{grn: grn, live: grn, details: true}}
+	ctx := context.Background()
+
+	// gone, not failed
+	ev, err := source.GetEvent(ctx, "102")
+	if ev != nil || err != nil {
+		t.Errorf("GetEvent(102) = %v, %v, want nil, nil", ev, err)
+	}
+
+	// failed
+	if _, err := source.GetEvent(ctx, "103"); err == nil {
+		t.Error("GetEvent(103): no error")
+	}
+
+	// 102 skipped
+	events, err := source.ListEvents(ctx, time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].ID != "101" {
+		t.Errorf("ListEvents = %d events, want 101 only", len(events))
+	}
+}
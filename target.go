@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// CalendarTarget ...
+// a calendar Garoon events are synced into.
+// events are in the form of Gcal events, with extended properties as sync keys.
+type CalendarTarget interface {
+	// List returns events between start and end
+	List(ctx context.Context, start, end time.Time) ([]*calendar.Event, error)
+
+	// FindBySourceID returns an event having all the extended properties ("key=value"),
+	// or nil if not found
+	FindBySourceID(ctx context.Context, epexprs ...string) (*calendar.Event, error)
+
+	// Insert adds an event
+	Insert(ctx context.Context, event *calendar.Event) error
+
+	// Update overwrites an event returned by List or FindBySourceID
+	Update(ctx context.Context, event *calendar.Event) error
+
+	// Delete removes an event returned by List or FindBySourceID
+	Delete(ctx context.Context, event *calendar.Event) error
+}
+
+// gcalTarget ...
+// a Google Calendar
+type gcalTarget struct {
+	Service    *calendar.Service
+	CalendarID string
+}
+
+// newGcalTarget ...
+// logs in to Gcal and selects a calendar (default: primary)
+func newGcalTarget(config *GcalConfig, profileName, legacyCacheDirName string) (*gcalTarget, error) {
+	// Google Calendar login (borrowed from sample codes)
+
+	gcal, err := LoginGcal(config, profileName, legacyCacheDirName)
+	if err != nil {
+		return nil, err
+	}
+
+	gcalCalendarID := config.CalendarID
+	if gcalCalendarID == "" {
+		//listRes, err := gcal.CalendarList.List().Fields("items/id").Do()
+		listRes, err := gcal.CalendarList.List().Fields("items(id,accessRole,deleted,primary,selected)").Do()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to fetch a list of Gcal calendars: %v", err)
+		}
+		//gcalCalendarID := listRes.Items[0].Id
+		for _, c := range listRes.Items {
+			if c.Primary {
+				gcalCalendarID = c.Id
+				break
+			}
+		}
+		if gcalCalendarID == "" {
+			return nil, errors.New("No primary calendar.")
+		}
+	}
+
+	return &gcalTarget{Service: gcal, CalendarID: gcalCalendarID}, nil
+}
+
+func (t *gcalTarget) List(ctx context.Context, start, end time.Time) ([]*calendar.Event, error) {
+	events, err := FetchGcalEventListByDatetime(ctx, t.Service, t.CalendarID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	return events.Items, nil
+}
+
+func (t *gcalTarget) FindBySourceID(ctx context.Context, epexprs ...string) (*calendar.Event, error) {
+	return FetchEventByExtendedProperty(ctx, t.Service, t.CalendarID, epexprs...)
+}
+
+func (t *gcalTarget) Insert(ctx context.Context, event *calendar.Event) error {
+	_, err := t.Service.Events.Insert(t.CalendarID, event).SupportsAttachments(true).Context(ctx).Do()
+	return err
+}
+
+func (t *gcalTarget) Update(ctx context.Context, event *calendar.Event) error {
+	_, err := t.Service.Events.Update(t.CalendarID, event.Id, event).SupportsAttachments(true).Context(ctx).Do()
+	return err
+}
+
+func (t *gcalTarget) Delete(ctx context.Context, event *calendar.Event) error {
+	// tell from deletion by the user
+	patch := &calendar.Event{
+		ExtendedProperties: &calendar.EventExtendedProperties{
+			Private: map[string]string{gcalEPKeyDeleted: "true"},
+		},
+	}
+	_, err := t.Service.Events.Patch(t.CalendarID, event.Id, patch).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+
+	return t.Service.Events.Delete(t.CalendarID, event.Id).Context(ctx).Do()
+}